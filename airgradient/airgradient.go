@@ -2,11 +2,16 @@ package airgradient
 
 import (
 	"encoding/json"
+	"math"
 	"net/http"
 	"strconv"
+	"time"
 
+	"neverending.dev/weather/calibration"
 	"neverending.dev/weather/measurement/Humidity"
 	"neverending.dev/weather/measurement/Temperature"
+	"neverending.dev/weather/quality"
+	"neverending.dev/weather/state"
 )
 
 type AirGradientStationStatus int8
@@ -43,6 +48,7 @@ type AirGradientStation struct {
 	CO2            uint64
 	Temperature    Temperature.Temperature
 	Humidity       Humidity.Humidity
+	LastSeen       time.Time
 }
 
 var AG = AirGradientStation{
@@ -64,6 +70,19 @@ type AirGradientJSON struct {
 	Humidity       string `json:"rhum"`
 }
 
+// listeners are called each time a report has been processed
+var listeners []func()
+
+// OnReport registers f to be called after each report from the AirGradient has been processed
+func OnReport(f func()) {
+	listeners = append(listeners, f)
+}
+
+// accept runs a reading through the quality checks, field is the JSON field the reading was posted in
+func accept(sensor string, field string, quantity string, value float64) bool {
+	return quality.Accept("airgradient", sensor, field, quantity, value)
+}
+
 func ReportHandler(w http.ResponseWriter, req *http.Request) {
 	var m AirGradientJSON
 
@@ -82,34 +101,59 @@ func ReportHandler(w http.ResponseWriter, req *http.Request) {
 	//j, _ := json.MarshalIndent(m, "", "  ")
 	//fmt.Println(string(j))
 
+	Ingest(m)
+	w.Write([]byte("OK"))
+}
+
+// Ingest updates the station from a report, fields that are empty are left unchanged
+func Ingest(m AirGradientJSON) {
+	state.Ingest(func() { ingest(m) }, listeners)
+}
+
+// Snapshot returns a copy of the station, safe to read while reports are being received
+func Snapshot() AirGradientStation {
+	ag := AirGradientStation{}
+	state.Read(func() { ag = AG })
+	return ag
+}
+
+func ingest(m AirGradientJSON) {
 	// if req.PostForm.Get("station_id") != "" {
 	// Indicate the structure is being updated
 	AG.Status = NotReady
 
 	AG.ID = m.ID
+	AG.LastSeen = time.Now()
 
 	if rssi, err := strconv.ParseInt(m.SignalStrength, 10, 64); err == nil {
 		AG.SignalStrength = rssi
 	}
 
 	if value, err := strconv.ParseUint(m.PM2dot5, 10, 64); err == nil {
-		AG.PM2dot5 = value
+		if r := math.Max(0, math.Round(calibration.Value("airgradient", m.ID, "pm", float64(value)))); accept(m.ID, "pm02", "pm", r) {
+			AG.PM2dot5 = uint64(r)
+		}
 	}
 
 	if value, err := strconv.ParseUint(m.CO2, 10, 64); err == nil {
-		AG.CO2 = value
+		if r := math.Max(0, math.Round(calibration.Value("airgradient", m.ID, "co2", float64(value)))); accept(m.ID, "rco2", "co2", r) {
+			AG.CO2 = uint64(r)
+		}
 	}
 
 	if value, err := strconv.ParseFloat(m.Temperature, 64); err == nil {
-		AG.Temperature = Temperature.New(value, Temperature.Celsius)
+		if r := calibration.Temperature("airgradient", m.ID, Temperature.New(value, Temperature.Celsius)); accept(m.ID, "atmp", "temperature", r.Get(Temperature.Celsius)) {
+			AG.Temperature = r
+		}
 	}
 
 	if value, err := strconv.ParseInt(m.Humidity, 10, 64); err == nil {
-		AG.Humidity = Humidity.New(value)
+		if r := calibration.Humidity("airgradient", m.ID, Humidity.New(value)); accept(m.ID, "rhum", "humidity", float64(r.Get())) {
+			AG.Humidity = r
+		}
 	}
 
 	// Indicate the structure has finished updating
 	AG.Status = Ready
 	// }
-	w.Write([]byte("OK"))
 }
@@ -0,0 +1,374 @@
+package alerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/metrics"
+)
+
+/*
+ * Alerting rules evaluated against the exporter's metrics at the configured interval. Each series
+ * of a rule's metric is alerted on separately, so a rule on ecowitt_soil_sensor_moisture covers
+ * every channel. An alert is pending while the condition holds for less than the rule's for
+ * duration, then fires and notifies the webhooks. It resolves, notifying again, once the value
+ * has recovered past the threshold by the hysteresis or the series is no longer exported.
+ */
+
+type State string
+
+const (
+	Pending  State = "pending"
+	Firing   State = "firing"
+	Resolved State = "resolved"
+)
+
+// Alert is a rule that matched a single series
+type Alert struct {
+	Name       string            `json:"name"`
+	Labels     map[string]string `json:"labels"` // the series labels with the rule's labels and alertname
+	State      State             `json:"state"`
+	Value      float64           `json:"value"`
+	Threshold  float64           `json:"threshold"`
+	Summary    string            `json:"summary,omitempty"`
+	ActiveAt   time.Time         `json:"active_at"` // when the condition started to hold
+	FiredAt    *time.Time        `json:"fired_at,omitempty"`
+	ResolvedAt *time.Time        `json:"resolved_at,omitempty"`
+}
+
+var lock sync.Mutex
+var active = map[string]*Alert{}
+var snapshot func() map[string]string
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// maxQueue is the number of notifications kept for each webhook while it is slow to respond
+const maxQueue = 100
+
+// queues holds the notifications waiting for each webhook, sent in the order the alerts changed
+var queues = []chan []Alert{}
+
+// Start evaluates the configured rules against the metrics returned by report
+func Start(report func() map[string]string) {
+	snapshot = report
+	if len(config.Config.Alerts.Rules) == 0 {
+		return
+	}
+
+	for _, webhook := range config.Config.Alerts.Webhooks {
+		queue := make(chan []Alert, maxQueue)
+		queues = append(queues, queue)
+		go send(webhook, queue)
+	}
+
+	go func() {
+		for now := range time.Tick(config.Config.Alerts.Every()) {
+			Evaluate(now)
+		}
+	}()
+}
+
+func compare(op string, value float64, threshold float64) bool {
+	switch op {
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "==":
+		return value == threshold
+	case "!=":
+		return value != threshold
+	}
+	return false
+}
+
+// recovered reports whether the value has moved back past the threshold by the hysteresis
+func recovered(rule config.AlertRule, value float64) bool {
+	switch rule.Op {
+	case "<", "<=":
+		return !compare(rule.Op, value, rule.Threshold+rule.Hysteresis)
+	case ">", ">=":
+		return !compare(rule.Op, value, rule.Threshold-rule.Hysteresis)
+	}
+	return !compare(rule.Op, value, rule.Threshold)
+}
+
+func matches(labels map[string]string, match map[string]string) bool {
+	for label, value := range match {
+		if labels[label] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func newAlert(rule config.AlertRule, labels map[string]string, now time.Time) *Alert {
+	a := &Alert{
+		Name:      rule.Name,
+		Labels:    map[string]string{},
+		State:     Pending,
+		Threshold: rule.Threshold,
+		Summary:   rule.Summary,
+		ActiveAt:  now,
+	}
+	for label, value := range labels {
+		a.Labels[label] = value
+	}
+	for label, value := range rule.Labels {
+		a.Labels[label] = value
+	}
+	a.Labels["alertname"] = rule.Name
+	return a
+}
+
+// Evaluate checks every rule against the current metrics and notifies the webhooks of the alerts
+// that have fired or resolved
+func Evaluate(now time.Time) {
+	report := snapshot()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	seen := map[string]bool{}
+	changed := []Alert{}
+
+	for _, rule := range config.Config.Alerts.Rules {
+		for series, text := range report {
+			name, labels := metrics.Parse(series)
+			if name != rule.Metric || !matches(labels, rule.Match) {
+				continue
+			}
+			value, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				continue
+			}
+
+			key := rule.Name + "/" + series
+			a, ok := active[key]
+			if !ok {
+				if !compare(rule.Op, value, rule.Threshold) {
+					continue
+				}
+				a = newAlert(rule, labels, now)
+				active[key] = a
+			}
+			seen[key] = true
+			a.Value = value
+
+			switch {
+			case a.State == Pending && !compare(rule.Op, value, rule.Threshold):
+				delete(active, key)
+			case a.State == Pending && now.Sub(a.ActiveAt) >= rule.Duration():
+				a.State = Firing
+				fired := now
+				a.FiredAt = &fired
+				changed = append(changed, *a)
+			case a.State == Firing && recovered(rule, value):
+				changed = append(changed, resolve(key, now))
+			}
+		}
+	}
+
+	for key, a := range active {
+		if seen[key] {
+			continue
+		}
+		if a.State == Firing {
+			changed = append(changed, resolve(key, now))
+		} else {
+			delete(active, key)
+		}
+	}
+
+	if len(changed) == 0 {
+		return
+	}
+	for i, queue := range queues {
+		select {
+		case queue <- changed:
+		default:
+			log.Printf("alerts: %s is not keeping up, dropping notification", config.Config.Alerts.Webhooks[i].URL)
+		}
+	}
+}
+
+// resolve removes a firing alert, returning it resolved
+func resolve(key string, now time.Time) Alert {
+	a := *active[key]
+	delete(active, key)
+
+	a.State = Resolved
+	a.ResolvedAt = &now
+	return a
+}
+
+// Active returns the pending and firing alerts
+func Active() []Alert {
+	lock.Lock()
+	defer lock.Unlock()
+
+	alerts := []Alert{}
+	for _, a := range active {
+		alerts = append(alerts, *a)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		return metrics.Series(alerts[i].Name, alerts[i].Labels) < metrics.Series(alerts[j].Name, alerts[j].Labels)
+	})
+	return alerts
+}
+
+// Report adds the pending and firing alerts to the exporter report
+func Report(report map[string]string) {
+	for _, a := range Active() {
+		labels := map[string]string{"alertstate": string(a.State)}
+		for label, value := range a.Labels {
+			labels[label] = value
+		}
+		report[metrics.Series("weather_alert", labels)] = "1"
+	}
+}
+
+// Handler serves the pending and firing alerts as JSON
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(Active()); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+// payload is sent to json webhooks
+type payload struct {
+	Status string  `json:"status"` // firing if any of the alerts are firing
+	Alerts []Alert `json:"alerts"`
+}
+
+// alertmanagerAlert and alertmanagerPayload follow the Alertmanager webhook receiver format
+type alertmanagerAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+type alertmanagerPayload struct {
+	Version           string              `json:"version"`
+	GroupKey          string              `json:"groupKey"`
+	TruncatedAlerts   int                 `json:"truncatedAlerts"`
+	Status            string              `json:"status"`
+	Receiver          string              `json:"receiver"`
+	GroupLabels       map[string]string   `json:"groupLabels"`
+	CommonLabels      map[string]string   `json:"commonLabels"`
+	CommonAnnotations map[string]string   `json:"commonAnnotations"`
+	ExternalURL       string              `json:"externalURL"`
+	Alerts            []alertmanagerAlert `json:"alerts"`
+}
+
+func status(alerts []Alert) string {
+	for _, a := range alerts {
+		if a.State == Firing {
+			return string(Firing)
+		}
+	}
+	return string(Resolved)
+}
+
+func fingerprint(labels map[string]string) string {
+	h := fnv.New64a()
+	for _, label := range metrics.Names(labels) {
+		fmt.Fprintf(h, "%s\xff%s\xff", label, labels[label])
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+func alertmanager(alerts []Alert) alertmanagerPayload {
+	p := alertmanagerPayload{
+		Version:           "4",
+		GroupKey:          "{}:{}",
+		Status:            status(alerts),
+		Receiver:          "weather",
+		GroupLabels:       map[string]string{},
+		CommonLabels:      map[string]string{},
+		CommonAnnotations: map[string]string{},
+	}
+
+	for label, value := range alerts[0].Labels {
+		common := true
+		for _, a := range alerts[1:] {
+			if a.Labels[label] != value {
+				common = false
+			}
+		}
+		if common {
+			p.CommonLabels[label] = value
+		}
+	}
+
+	for _, a := range alerts {
+		am := alertmanagerAlert{
+			Status:      string(a.State),
+			Labels:      a.Labels,
+			Annotations: map[string]string{"value": strconv.FormatFloat(a.Value, 'f', -1, 64)},
+			StartsAt:    a.ActiveAt,
+			Fingerprint: fingerprint(a.Labels),
+		}
+		if a.FiredAt != nil {
+			am.StartsAt = *a.FiredAt
+		}
+		if a.ResolvedAt != nil {
+			am.EndsAt = *a.ResolvedAt
+		}
+		if a.Summary != "" {
+			am.Annotations["summary"] = a.Summary
+		}
+		p.Alerts = append(p.Alerts, am)
+	}
+	return p
+}
+
+// send notifies a webhook of each change queued for it, one at a time so a resolve never
+// arrives before the alert fired
+func send(webhook config.Webhook, queue chan []Alert) {
+	for alerts := range queue {
+		notify(webhook, alerts)
+	}
+}
+
+// notify sends the alerts that have fired or resolved to a webhook
+func notify(webhook config.Webhook, alerts []Alert) {
+	var body interface{} = payload{Status: status(alerts), Alerts: alerts}
+	if webhook.Format == "alertmanager" {
+		body = alertmanager(alerts)
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("alerts: unable to encode notification: %v", err)
+		return
+	}
+
+	resp, err := client.Post(webhook.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Printf("alerts: unable to notify %s: %v", webhook.URL, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Printf("alerts: unable to notify %s: %s", webhook.URL, resp.Status)
+	}
+}
@@ -0,0 +1,153 @@
+package alerts
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"neverending.dev/weather/config"
+)
+
+type notification struct {
+	path string
+	body []byte
+}
+
+// receive returns the next notification posted to the stub webhook
+func receive(t *testing.T, received chan notification) notification {
+	t.Helper()
+	select {
+	case n := <-received:
+		return n
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification received")
+	}
+	return notification{}
+}
+
+func TestWebhooks(t *testing.T) {
+	received := make(chan notification, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		body, _ := io.ReadAll(r.Body)
+		received <- notification{path: r.URL.Path, body: body}
+	}))
+	defer server.Close()
+
+	config.Config.Alerts = config.Alerts{
+		Interval: "1h",
+		Rules: []config.AlertRule{{
+			Name:       "Freezing",
+			Metric:     "ecowitt_outdoor_temperature",
+			Op:         "<",
+			Threshold:  0,
+			Hysteresis: 0.5,
+			Labels:     map[string]string{"severity": "warning"},
+			Summary:    "Outdoor temperature below 0 °C",
+		}},
+		Webhooks: []config.Webhook{
+			{URL: server.URL + "/json", Format: "json"},
+			{URL: server.URL + "/alertmanager", Format: "alertmanager"},
+		},
+	}
+
+	temperature := "-1.5"
+	Start(func() map[string]string {
+		return map[string]string{`ecowitt_outdoor_temperature{sensor="outdoor"}`: temperature}
+	})
+
+	fired := time.Date(2026, 1, 10, 6, 0, 0, 0, time.UTC)
+	Evaluate(fired)
+	if active := Active(); len(active) != 1 || active[0].State != Firing {
+		t.Fatalf("Active() = %+v, want one firing alert", active)
+	}
+
+	// within the hysteresis the alert keeps firing without notifying again
+	temperature = "0.2"
+	Evaluate(fired.Add(time.Minute))
+
+	temperature = "1"
+	resolved := fired.Add(2 * time.Minute)
+	Evaluate(resolved)
+	if active := Active(); len(active) != 0 {
+		t.Fatalf("Active() = %+v, want none", active)
+	}
+
+	notifications := map[string][][]byte{}
+	for i := 0; i < 4; i++ {
+		n := receive(t, received)
+		notifications[n.path] = append(notifications[n.path], n.body)
+	}
+
+	t.Run("json", func(t *testing.T) {
+		bodies := notifications["/json"]
+		if len(bodies) != 2 {
+			t.Fatalf("got %d notifications, want 2", len(bodies))
+		}
+		for i, want := range []State{Firing, Resolved} {
+			var p payload
+			if err := json.Unmarshal(bodies[i], &p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Status != string(want) || len(p.Alerts) != 1 {
+				t.Fatalf("notification %d: status %q with %d alerts, want %q with 1", i, p.Status, len(p.Alerts), want)
+			}
+			a := p.Alerts[0]
+			if a.State != want || a.Name != "Freezing" || a.Threshold != 0 {
+				t.Errorf("notification %d: alert %+v", i, a)
+			}
+			for label, value := range map[string]string{"alertname": "Freezing", "severity": "warning", "sensor": "outdoor"} {
+				if a.Labels[label] != value {
+					t.Errorf("notification %d: label %s = %q, want %q", i, label, a.Labels[label], value)
+				}
+			}
+		}
+
+		var p payload
+		json.Unmarshal(bodies[1], &p)
+		if a := p.Alerts[0]; a.Value != 1 || a.FiredAt == nil || !a.FiredAt.Equal(fired) || a.ResolvedAt == nil || !a.ResolvedAt.Equal(resolved) {
+			t.Errorf("resolved alert %+v", a)
+		}
+	})
+
+	t.Run("alertmanager", func(t *testing.T) {
+		bodies := notifications["/alertmanager"]
+		if len(bodies) != 2 {
+			t.Fatalf("got %d notifications, want 2", len(bodies))
+		}
+		for i, want := range []State{Firing, Resolved} {
+			var p alertmanagerPayload
+			if err := json.Unmarshal(bodies[i], &p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Version != "4" || p.Status != string(want) || p.Receiver != "weather" || len(p.Alerts) != 1 {
+				t.Fatalf("notification %d: %+v", i, p)
+			}
+			if p.CommonLabels["alertname"] != "Freezing" {
+				t.Errorf("notification %d: common labels %v", i, p.CommonLabels)
+			}
+			a := p.Alerts[0]
+			if a.Status != string(want) || a.Labels["severity"] != "warning" || a.Annotations["summary"] != "Outdoor temperature below 0 °C" {
+				t.Errorf("notification %d: alert %+v", i, a)
+			}
+			if !a.StartsAt.Equal(fired) || a.Fingerprint != fingerprint(a.Labels) {
+				t.Errorf("notification %d: startsAt %v fingerprint %s", i, a.StartsAt, a.Fingerprint)
+			}
+		}
+
+		var firing, cleared alertmanagerPayload
+		json.Unmarshal(bodies[0], &firing)
+		if a := firing.Alerts[0]; !a.EndsAt.IsZero() || a.Annotations["value"] != "-1.5" {
+			t.Errorf("firing alert %+v", a)
+		}
+		json.Unmarshal(bodies[1], &cleared)
+		if a := cleared.Alerts[0]; !a.EndsAt.Equal(resolved) || a.Annotations["value"] != "1" {
+			t.Errorf("resolved alert %+v", a)
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package calibration
+
+import (
+	"math"
+
+	"neverending.dev/weather/config"
+	humidity "neverending.dev/weather/measurement/Humidity"
+	pressure "neverending.dev/weather/measurement/Pressure"
+	rainfall "neverending.dev/weather/measurement/Rainfall"
+	temperature "neverending.dev/weather/measurement/Temperature"
+	velocity "neverending.dev/weather/measurement/Velocity"
+)
+
+/*
+ * Calibration applies the configured gain and offset to readings as they are parsed, before they
+ * are stored in the station. Offsets are converted to the unit of the reading so an offset given
+ * in °C is applied correctly to the °F reported by the ecowitt gateway.
+ */
+
+// find returns the calibration for a sensor's quantity, an entry for the specific sensor is
+// preferred over one for every sensor of the station
+func find(station string, sensor string, quantity string) (config.Calibration, bool) {
+	found := config.Calibration{}
+	ok := false
+	for _, c := range config.Config.Calibration {
+		if c.Station != station || c.Quantity != quantity {
+			continue
+		}
+		if c.Sensor == sensor {
+			return c, true
+		}
+		if c.Sensor == "" {
+			found, ok = c, true
+		}
+	}
+	return found, ok
+}
+
+// Temperature returns the calibrated temperature in the same unit as t. The gain is applied in
+// °C whatever unit the sensor reports, so a gain scales the same reading the same way.
+func Temperature(station string, sensor string, t temperature.Temperature) temperature.Temperature {
+	c, ok := find(station, sensor, "temperature")
+	if !ok {
+		return t
+	}
+
+	value := t.Get(temperature.Celsius) * c.Gain
+	if offset, err := temperature.FromString(c.Offset); err == nil {
+		value += temperature.Delta(offset.Get(offset.Unit()), offset.Unit(), temperature.Celsius)
+	}
+	unit := t.Unit()
+	return temperature.New(temperature.New(value, temperature.Celsius).Get(unit), unit)
+}
+
+// Pressure returns the calibrated pressure in the same unit as p
+func Pressure(station string, sensor string, p pressure.Pressure) pressure.Pressure {
+	c, ok := find(station, sensor, "pressure")
+	if !ok {
+		return p
+	}
+
+	unit := p.Unit()
+	value := p.Get(unit) * c.Gain
+	if offset, err := pressure.FromString(c.Offset); err == nil {
+		value += offset.Get(unit)
+	}
+	return pressure.New(value, unit)
+}
+
+// Velocity returns the calibrated wind speed in the same unit as v
+func Velocity(station string, sensor string, v velocity.Velocity) velocity.Velocity {
+	c, ok := find(station, sensor, "wind")
+	if !ok {
+		return v
+	}
+
+	unit := v.Unit()
+	value := v.Get(unit) * c.Gain
+	if offset, err := velocity.FromString(c.Offset); err == nil {
+		value += offset.Get(unit)
+	}
+	return velocity.New(math.Max(0, value), unit)
+}
+
+// Rainfall returns the calibrated rainfall in the same unit as r
+func Rainfall(station string, sensor string, r rainfall.Rainfall) rainfall.Rainfall {
+	c, ok := find(station, sensor, "rain")
+	if !ok {
+		return r
+	}
+
+	unit := r.Unit()
+	value := r.Get(unit) * c.Gain
+	if offset, err := rainfall.FromString(c.Offset); err == nil {
+		value += offset.Get(unit)
+	}
+	return rainfall.New(math.Max(0, value), unit)
+}
+
+// Humidity returns the calibrated relative humidity, limited to 0-100%
+func Humidity(station string, sensor string, h humidity.Humidity) humidity.Humidity {
+	value := Value(station, sensor, "humidity", float64(h.Get()))
+	value = math.Max(0, math.Min(100, value))
+	return humidity.New(int64(math.Round(value)))
+}
+
+// Value returns the calibrated reading of a quantity without units, solar, uv, co2 or pm
+func Value(station string, sensor string, quantity string, value float64) float64 {
+	c, ok := find(station, sensor, quantity)
+	if !ok {
+		return value
+	}
+
+	offset, _ := c.Value()
+	return value*c.Gain + offset
+}
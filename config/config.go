@@ -0,0 +1,1135 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"neverending.dev/weather/measurement/Moisture"
+	"neverending.dev/weather/measurement/Pressure"
+	"neverending.dev/weather/measurement/Rainfall"
+	"neverending.dev/weather/measurement/Temperature"
+	"neverending.dev/weather/measurement/Velocity"
+)
+
+// Configuration holds the server parameters read from the JSON configuration file
+type Configuration struct {
+	Listen   string  `json:"listen"`   // address the HTTP server listens on
+	DataDir  string  `json:"data_dir"` // directory used to persist state across restarts
+	Timezone string  `json:"timezone"` // IANA timezone used to align daily/monthly/yearly periods
+	Station  Station `json:"station"`
+	Units    Units   `json:"units"`
+
+	SensorTimeout string `json:"sensor_timeout"` // a sensor missing from reports for this long is down, e.g. "10m"
+
+	DegreeDays DegreeDays `json:"degree_days"`
+	Irrigation []Zone     `json:"irrigation"`
+
+	SoilCalibration []SoilCalibration `json:"soil_calibration"`
+	Calibration     []Calibration     `json:"calibration"`
+	Quality         Quality           `json:"quality"`
+	Sensors         []Sensor          `json:"sensors"`
+	Alerts          Alerts            `json:"alerts"`
+	Frost           Frost             `json:"frost"`
+	Lightning       Lightning         `json:"lightning"`
+	MQTT            MQTT              `json:"mqtt"`
+	Influx          Influx            `json:"influx"`
+	RemoteWrite     RemoteWrite       `json:"remote_write"`
+	OTLP            OTLP              `json:"otlp"`
+	Graphite        Graphite          `json:"graphite"`
+	StatsD          StatsD            `json:"statsd"`
+	Uploads         []Upload          `json:"uploads"`
+	Relays          []Relay           `json:"relays"`
+	CWOP            CWOP              `json:"cwop"`
+
+	location      *time.Location
+	sensorTimeout time.Duration
+}
+
+// Station describes the weather station location, used in reports and calculations
+type Station struct {
+	Name      string  `json:"name"`
+	ID        string  `json:"id"`        // identifies the ecowitt station in the API, left out when empty
+	Latitude  float64 `json:"latitude"`  // decimal degrees, positive north
+	Longitude float64 `json:"longitude"` // decimal degrees, positive east
+	Elevation float64 `json:"elevation"` // metres above sea level
+
+	AnemometerHeight float64 `json:"anemometer_height"` // metres above ground
+}
+
+// Units are the units used when presenting readings, e.g. "°C", "km/h", "mm", "hPa"
+type Units struct {
+	Temperature string `json:"temperature"`
+	Wind        string `json:"wind"`
+	Rain        string `json:"rain"`
+	Pressure    string `json:"pressure"`
+}
+
+// DegreeDays configures heating, cooling and growing degree day calculations. Temperatures are
+// written with their unit, e.g. "18 °C" or "65 °F".
+type DegreeDays struct {
+	HeatingBase string   `json:"heating_base"`
+	CoolingBase string   `json:"cooling_base"`
+	SeasonStart string   `json:"season_start"` // MM-DD the season to date totals are reset
+	Sources     []string `json:"sources"`      // "outdoor" or a temperature/humidity channel, e.g. "th1"
+	Crops       []Crop   `json:"crops"`
+}
+
+// Crop is a growing degree day profile
+type Crop struct {
+	Name string `json:"name"`
+	Base string `json:"base"` // no growth below this temperature
+	Cap  string `json:"cap"`  // no additional growth above this temperature
+}
+
+// Zone is an irrigation zone monitored by a soil moisture sensor
+type Zone struct {
+	Name            string  `json:"name"`
+	SoilChannel     int     `json:"soil_channel"`
+	CropCoefficient float64 `json:"crop_coefficient"` // Kc, crop evapotranspiration relative to ETo
+	TargetMin       float64 `json:"target_min"`       // % moisture, water below this
+	TargetMax       float64 `json:"target_max"`       // % moisture, water up to this
+	RootDepth       float64 `json:"root_depth"`       // mm
+	ApplicationRate float64 `json:"application_rate"` // mm/hour delivered by the irrigation system
+}
+
+// SoilCalibration converts the raw AD readings of a WH51 soil moisture channel to moisture, either
+// with a two point dry/wet calibration or a multi-point piecewise linear curve
+type SoilCalibration struct {
+	Channel int              `json:"channel"`
+	DryAD   float64          `json:"dry_ad"` // reading in dry soil, 0%
+	WetAD   float64          `json:"wet_ad"` // reading in saturated soil, 100%
+	Points  []Moisture.Point `json:"points"` // used instead of dry_ad and wet_ad when given
+}
+
+// Calibration corrects a sensor's readings of a quantity before they are stored, the reading is
+// multiplied by the gain then the offset added. Offsets of quantities with units are written with
+// their unit, e.g. "-0.8 °C", and converted to the units the sensor reports in. Temperatures are
+// calibrated in °C, the gain scales the reading in °C whatever unit the sensor reports in.
+type Calibration struct {
+	Station  string  `json:"station"`  // ecowitt or airgradient
+	Sensor   string  `json:"sensor"`   // gateway, outdoor or th<channel> for ecowitt, empty matches all
+	Quantity string  `json:"quantity"` // temperature, humidity, pressure, wind, rain, solar, uv, co2 or pm
+	Offset   string  `json:"offset"`
+	Gain     float64 `json:"gain"`
+}
+
+// Quality configures the checks incoming readings must pass before they are stored. Quantities
+// are temperature (°C), humidity (%), pressure (hPa), wind_speed and wind_gust (km/h), rain_rate
+// (mm/h), solar (W/m2), uv, co2 (ppm), pm (µg/m3) and moisture (%).
+type Quality struct {
+	Action     string             `json:"action"`      // drop suspect readings or mark them and keep them
+	Ranges     map[string]Range   `json:"ranges"`      // physically possible readings
+	RateLimits map[string]float64 `json:"rate_limits"` // largest change per minute
+	Spike      Spike              `json:"spike"`
+}
+
+// Range is the lowest and highest reading accepted
+type Range struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// Spike configures the Hampel filter, a reading further than threshold x the scaled median
+// absolute deviation from the median of the last window readings is a spike. MinDeviation sets
+// the smallest deviation treated as a spike for each quantity checked, so steady readings with
+// no deviation do not turn every change into a spike.
+type Spike struct {
+	Window       int                `json:"window"`
+	Threshold    float64            `json:"threshold"`
+	MinDeviation map[string]float64 `json:"min_deviation"`
+}
+
+// Sensor describes where a sensor is and what it measures, attached to its readings as labels
+type Sensor struct {
+	Station string            `json:"station"` // ecowitt or airgradient
+	Sensor  string            `json:"sensor"`  // gateway, outdoor, lightning, th<channel>, soil<channel> or leak<channel> for ecowitt, the station ID for airgradient
+	Name    string            `json:"name"`
+	Zone    string            `json:"zone"` // room or area
+	Indoor  *bool             `json:"indoor"`
+	Floor   *int              `json:"floor"`
+	Tags    map[string]string `json:"tags"`
+}
+
+// Frost configures the frost risk estimate
+type Frost struct {
+	Horizon string   `json:"horizon"` // how far ahead the minimum temperature is projected, e.g. "6h"
+	Ground  []string `json:"ground"`  // temperature/humidity channels at ground level, e.g. "th3"
+}
+
+// Lightning configures storm tracking from the WH57 lightning sensor
+type Lightning struct {
+	Radius   float64 `json:"radius"`    // km, strikes within this distance hold off the all clear
+	AllClear string  `json:"all_clear"` // time since the last nearby strike before it is all clear, e.g. "30m"
+}
+
+// MQTT configures publishing readings to an MQTT broker. Topics may include {station}, {sensor}
+// and {field}, which are replaced with the names used in the metrics.
+type MQTT struct {
+	Broker     string `json:"broker"` // e.g. tcp://localhost:1883, publishing is off when empty
+	ClientID   string `json:"client_id"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	StateTopic string `json:"state_topic"` // JSON object of every reading of a sensor
+	ValueTopic string `json:"value_topic"` // each reading on its own, empty to only publish the state
+	Status     string `json:"status_topic"`
+
+	Discovery       bool   `json:"discovery"` // publish Home Assistant discovery messages
+	DiscoveryPrefix string `json:"discovery_prefix"`
+
+	Subscriptions []Subscription `json:"subscriptions"`
+}
+
+// Subscription maps the messages on an MQTT topic to the readings of a sensor
+type Subscription struct {
+	Topic   string         `json:"topic"`   // may include + and # wildcards
+	Station string         `json:"station"` // ecowitt or airgradient
+	Sensor  string         `json:"sensor"`  // gateway, outdoor, th<channel> or soil<channel> (1-8) for ecowitt, the station ID for airgradient
+	Fields  []FieldMapping `json:"fields"`
+}
+
+// FieldMapping takes a reading from a JSON message
+type FieldMapping struct {
+	Path     string `json:"path"`     // dot separated path to the value, empty when the message is the value
+	Quantity string `json:"quantity"` // the reading, named as in the published readings, e.g. temperature or wind_gust
+	Unit     string `json:"unit"`     // unit of the value, e.g. "°F", the unit used by the metrics when empty
+}
+
+// Influx configures writing each reading to InfluxDB, or another database accepting the InfluxDB
+// line protocol, as it is received
+type Influx struct {
+	URL         string `json:"url"`     // e.g. http://localhost:8086, writing is off when empty
+	Version     int    `json:"version"` // 1 or 2
+	Measurement string `json:"measurement"`
+
+	Database        string `json:"database"` // version 1
+	RetentionPolicy string `json:"retention_policy"`
+	Username        string `json:"username"`
+	Password        string `json:"password"`
+
+	Org    string `json:"org"` // version 2
+	Bucket string `json:"bucket"`
+	Token  string `json:"token"`
+
+	BatchSize     int    `json:"batch_size"`     // lines sent in each write
+	FlushInterval string `json:"flush_interval"` // longest a line waits to be sent, e.g. "10s"
+	BufferSize    int    `json:"buffer_size"`    // lines kept, on disk, while the database is unavailable
+}
+
+// RemoteWrite configures pushing each reading to a Prometheus remote write endpoint, such as
+// Mimir, Thanos or VictoriaMetrics, as it is received
+type RemoteWrite struct {
+	URL         string            `json:"url"` // e.g. http://localhost:9009/api/v1/push, pushing is off when empty
+	Username    string            `json:"username"`
+	Password    string            `json:"password"`
+	BearerToken string            `json:"bearer_token"`
+	Headers     map[string]string `json:"headers"` // added to each request, e.g. X-Scope-OrgID
+	Labels      map[string]string `json:"labels"`  // added to every series
+
+	BatchSize     int    `json:"batch_size"`     // samples sent in each request
+	FlushInterval string `json:"flush_interval"` // longest a sample waits to be sent, e.g. "10s"
+	BufferSize    int    `json:"buffer_size"`    // samples kept, on disk, while the endpoint is unavailable
+}
+
+// OTLP configures exporting each reading as OpenTelemetry metrics to a collector over HTTP
+type OTLP struct {
+	Endpoint   string            `json:"endpoint"` // e.g. http://localhost:4318, exporting is off when empty
+	Headers    map[string]string `json:"headers"`  // added to each request, e.g. an API key
+	Attributes map[string]string `json:"resource_attributes"`
+}
+
+// Graphite configures sending each reading to Graphite, or Carbon, in the plaintext protocol
+type Graphite struct {
+	Address  string `json:"address"`  // host:port, e.g. localhost:2003, sending is off when empty
+	Protocol string `json:"protocol"` // tcp or udp
+	Prefix   string `json:"prefix"`
+	Template string `json:"template"` // path of each reading after the prefix, e.g. "{station}.{sensor}.{field}"
+}
+
+// StatsD configures sending each reading to StatsD as a gauge
+type StatsD struct {
+	Address  string `json:"address"` // host:port, e.g. localhost:8125, sending is off when empty
+	Prefix   string `json:"prefix"`
+	Template string `json:"template"`
+}
+
+// Upload configures re-publishing the observations of the Ecowitt station to a weather network
+type Upload struct {
+	Service  string `json:"service"`  // wunderground, windy, pwsweather, wow or openweathermap
+	Station  string `json:"station"`  // the station's ID at the service, the station index for windy
+	Key      string `json:"key"`      // the station's password, API key or authentication key
+	Interval string `json:"interval"` // shortest time between uploads, the service's limit when empty
+	URL      string `json:"url"`      // replaces the service's endpoint, e.g. for a test server
+}
+
+// uploadLimits is the shortest time between uploads each service accepts
+var uploadLimits = map[string]time.Duration{
+	"wunderground":   5 * time.Second,
+	"windy":          5 * time.Minute,
+	"pwsweather":     time.Minute,
+	"wow":            5 * time.Minute,
+	"openweathermap": time.Minute,
+}
+
+// Relay forwards each report posted by the Ecowitt gateway to another server accepting the Ecowitt
+// protocol, such as weewx or Home Assistant
+type Relay struct {
+	URL          string `json:"url"`
+	Passkey      string `json:"passkey"`       // replaces the gateway's PASSKEY, the gateway's is sent when empty
+	StripPasskey bool   `json:"strip_passkey"` // leaves out the PASSKEY
+}
+
+// CWOP configures sending the outdoor readings to the Citizen Weather Observer Program as APRS
+// weather packets, positioned at the station's latitude and longitude
+type CWOP struct {
+	Callsign string `json:"callsign"` // e.g. CW1234 or an amateur radio callsign, sending is off when empty
+	Passcode int    `json:"passcode"` // APRS-IS passcode, -1 for CW stations
+	Server   string `json:"server"`   // host:port of the APRS-IS server
+	Interval string `json:"interval"` // time between packets, 5 to 10 minutes
+}
+
+// Alerts configures threshold rules evaluated against the exported metrics, and the webhooks
+// notified when an alert fires or resolves
+type Alerts struct {
+	Interval string      `json:"interval"` // how often the rules are evaluated, e.g. "30s"
+	Rules    []AlertRule `json:"rules"`
+	Webhooks []Webhook   `json:"webhooks"`
+}
+
+// AlertRule compares each series of a metric with a threshold, in the units the metric is
+// exported in. A series fires once the condition has held for the duration and resolves once
+// the value has recovered past the threshold by the hysteresis.
+type AlertRule struct {
+	Name       string            `json:"name"`
+	Metric     string            `json:"metric"` // metric name without labels
+	Match      map[string]string `json:"match"`  // labels a series must have to be checked
+	Op         string            `json:"op"`     // <, <=, >, >=, == or !=
+	Threshold  float64           `json:"threshold"`
+	Hysteresis float64           `json:"hysteresis"`
+	For        string            `json:"for"` // e.g. "10m", empty fires immediately
+	Labels     map[string]string `json:"labels"`
+	Summary    string            `json:"summary"`
+}
+
+// Webhook is sent a JSON payload when an alert fires or resolves
+type Webhook struct {
+	URL    string `json:"url"`
+	Format string `json:"format"` // json or alertmanager
+}
+
+var Config = Configuration{
+	Listen:        ":8090",
+	DataDir:       "./data",
+	Timezone:      "Local",
+	SensorTimeout: "10m",
+	sensorTimeout: 10 * time.Minute,
+	Station: Station{
+		AnemometerHeight: 2.0,
+	},
+	Units: Units{
+		Temperature: "°C",
+		Wind:        "km/h",
+		Rain:        "mm",
+		Pressure:    "hPa",
+	},
+	Quality: Quality{
+		Action: "drop",
+		Ranges: map[string]Range{
+			"temperature": {Min: -39.9, Max: 60}, // sensors report -40 when they fail to read
+			"humidity":    {Min: 1, Max: 100},
+			"pressure":    {Min: 500, Max: 1100},
+			"wind_speed":  {Min: 0, Max: 250},
+			"wind_gust":   {Min: 0, Max: 350},
+			"rain_rate":   {Min: 0, Max: 500},
+			"solar":       {Min: 0, Max: 1800},
+			"uv":          {Min: 0, Max: 20},
+			"co2":         {Min: 300, Max: 10000},
+			"pm":          {Min: 0, Max: 1000},
+			"moisture":    {Min: 0, Max: 100},
+		},
+		RateLimits: map[string]float64{
+			"temperature": 3,
+			"humidity":    20,
+			"pressure":    2,
+		},
+		Spike: Spike{
+			Window:    7,
+			Threshold: 3,
+			MinDeviation: map[string]float64{
+				"wind_speed": 15,
+				"wind_gust":  30,
+			},
+		},
+	},
+	DegreeDays: DegreeDays{
+		HeatingBase: "65 °F",
+		CoolingBase: "65 °F",
+		SeasonStart: "01-01",
+		Sources:     []string{"outdoor"},
+	},
+	Alerts: Alerts{
+		Interval: "30s",
+	},
+	Frost: Frost{
+		Horizon: "6h",
+	},
+	Lightning: Lightning{
+		Radius:   16,
+		AllClear: "30m",
+	},
+	Influx: Influx{
+		Version:       2,
+		Measurement:   "weather",
+		BatchSize:     500,
+		FlushInterval: "10s",
+		BufferSize:    100000,
+	},
+	RemoteWrite: RemoteWrite{
+		BatchSize:     500,
+		FlushInterval: "10s",
+		BufferSize:    100000,
+	},
+	Graphite: Graphite{
+		Protocol: "tcp",
+		Prefix:   "weather",
+		Template: "{station}.{sensor}.{field}",
+	},
+	StatsD: StatsD{
+		Prefix:   "weather",
+		Template: "{station}.{sensor}.{field}",
+	},
+	CWOP: CWOP{
+		Passcode: -1,
+		Server:   "cwop.aprs.net:14580",
+		Interval: "5m",
+	},
+	MQTT: MQTT{
+		ClientID:        "weather",
+		StateTopic:      "weather/{station}/{sensor}",
+		ValueTopic:      "weather/{station}/{sensor}/{field}",
+		Status:          "weather/status",
+		DiscoveryPrefix: "homeassistant",
+	},
+	location: time.Local,
+}
+
+// Load reads the configuration file at path over the defaults. A missing file is not an error,
+// the defaults are used instead.
+func Load(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&Config); err != nil {
+		return err
+	}
+
+	loc, err := time.LoadLocation(Config.Timezone)
+	if err != nil {
+		return err
+	}
+	Config.location = loc
+
+	if Config.sensorTimeout, err = time.ParseDuration(Config.SensorTimeout); err != nil {
+		return fmt.Errorf("sensor_timeout: %v", err)
+	}
+
+	if _, err := Temperature.Parse(Config.Units.Temperature); err != nil {
+		return err
+	}
+	if _, err := Velocity.Parse(Config.Units.Wind); err != nil {
+		return err
+	}
+	if _, err := Rainfall.Parse(Config.Units.Rain); err != nil {
+		return err
+	}
+	if _, err := Pressure.Parse(Config.Units.Pressure); err != nil {
+		return err
+	}
+
+	if err := Config.DegreeDays.validate(); err != nil {
+		return fmt.Errorf("degree_days: %v", err)
+	}
+	for _, c := range Config.SoilCalibration {
+		if err := c.validate(); err != nil {
+			return fmt.Errorf("soil_calibration: %v", err)
+		}
+	}
+	if Config.Quality.Action != "drop" && Config.Quality.Action != "mark" {
+		return fmt.Errorf("quality: unknown action %q, expected drop or mark", Config.Quality.Action)
+	}
+	for _, sensor := range Config.Sensors {
+		if err := sensor.validate(); err != nil {
+			return fmt.Errorf("sensors: %v", err)
+		}
+	}
+	for i := range Config.Calibration {
+		if err := Config.Calibration[i].validate(); err != nil {
+			return fmt.Errorf("calibration: %v", err)
+		}
+	}
+	zones := map[string]bool{}
+	for _, zone := range Config.Irrigation {
+		if err := zone.validate(); err != nil {
+			return fmt.Errorf("irrigation: %v", err)
+		}
+		if zones[zone.Name] {
+			return fmt.Errorf("irrigation: zone %s is configured more than once", zone.Name)
+		}
+		zones[zone.Name] = true
+	}
+	if err := Config.Alerts.validate(); err != nil {
+		return fmt.Errorf("alerts: %v", err)
+	}
+	if err := Config.Frost.validate(); err != nil {
+		return fmt.Errorf("frost: %v", err)
+	}
+	if d, err := time.ParseDuration(Config.Lightning.AllClear); err != nil || d <= 0 {
+		return fmt.Errorf("lightning: invalid all_clear %q", Config.Lightning.AllClear)
+	}
+	if Config.Lightning.Radius <= 0 {
+		return fmt.Errorf("lightning: radius must be greater than 0")
+	}
+	if err := Config.MQTT.validate(); err != nil {
+		return fmt.Errorf("mqtt: %v", err)
+	}
+	if err := Config.Influx.validate(); err != nil {
+		return fmt.Errorf("influx: %v", err)
+	}
+	if err := Config.RemoteWrite.validate(); err != nil {
+		return fmt.Errorf("remote_write: %v", err)
+	}
+	if err := Config.OTLP.validate(); err != nil {
+		return fmt.Errorf("otlp: %v", err)
+	}
+	if err := Config.Graphite.validate(); err != nil {
+		return fmt.Errorf("graphite: %v", err)
+	}
+	if err := Config.StatsD.validate(); err != nil {
+		return fmt.Errorf("statsd: %v", err)
+	}
+	for _, upload := range Config.Uploads {
+		if err := upload.validate(); err != nil {
+			return fmt.Errorf("uploads: %v", err)
+		}
+	}
+	for _, relay := range Config.Relays {
+		if err := relay.validate(); err != nil {
+			return fmt.Errorf("relays: %v", err)
+		}
+	}
+	if err := Config.CWOP.validate(); err != nil {
+		return fmt.Errorf("cwop: %v", err)
+	}
+
+	return nil
+}
+
+// Location returns the configured local timezone
+func Location() *time.Location {
+	return Config.location
+}
+
+// SensorTimeout returns how long a sensor can be missing from reports before it is down
+func SensorTimeout() time.Duration {
+	return Config.sensorTimeout
+}
+
+// TemperatureUnit returns the configured temperature unit
+func (u Units) TemperatureUnit() Temperature.Unit {
+	unit, _ := Temperature.Parse(u.Temperature)
+	return unit
+}
+
+// WindUnit returns the configured wind speed unit
+func (u Units) WindUnit() Velocity.Unit {
+	unit, _ := Velocity.Parse(u.Wind)
+	return unit
+}
+
+// RainUnit returns the configured rainfall unit
+func (u Units) RainUnit() Rainfall.Unit {
+	unit, _ := Rainfall.Parse(u.Rain)
+	return unit
+}
+
+// PressureUnit returns the configured pressure unit
+func (u Units) PressureUnit() Pressure.Unit {
+	unit, _ := Pressure.Parse(u.Pressure)
+	return unit
+}
+
+var metricName = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+var callsign = regexp.MustCompile(`^[A-Z0-9]{3,6}(-[0-9]{1,2})?$`)
+
+// reservedLabels are used by the exporter and cannot be used as tags, a series with a label twice
+// fails the whole scrape
+var reservedLabels = map[string]bool{
+	"station": true, "sensor": true, "channel": true, "name": true, "zone": true, "indoor": true, "floor": true,
+	"state": true, "quantity": true, "reason": true, "field": true, "result": true, "service": true,
+	"target": true, "callsign": true, "alertname": true, "alertstate": true,
+}
+
+func (s Sensor) validate() error {
+	if s.Station != "ecowitt" && s.Station != "airgradient" {
+		return fmt.Errorf("unknown station %q", s.Station)
+	}
+	for tag := range s.Tags {
+		if !labelName.MatchString(tag) || reservedLabels[tag] || strings.HasPrefix(tag, "__") {
+			return fmt.Errorf("%s %s: invalid tag name %q", s.Station, s.Sensor, tag)
+		}
+	}
+	return nil
+}
+
+func (d DegreeDays) validate() error {
+	for _, t := range []string{d.HeatingBase, d.CoolingBase} {
+		if _, err := Temperature.FromString(t); err != nil {
+			return err
+		}
+	}
+	if _, err := time.Parse("01-02", d.SeasonStart); err != nil {
+		return fmt.Errorf("invalid season_start %q, expected MM-DD", d.SeasonStart)
+	}
+	for _, source := range d.Sources {
+		if source != "outdoor" && !(strings.HasPrefix(source, "th") && ecowittSensor(source)) {
+			return fmt.Errorf("unknown source %q, expected outdoor or th1-8", source)
+		}
+	}
+	for _, crop := range d.Crops {
+		if !metricName.MatchString(crop.Name) {
+			return fmt.Errorf("invalid crop name %q, only letters, digits and _ are allowed", crop.Name)
+		}
+		for _, t := range []string{crop.Base, crop.Cap} {
+			if _, err := Temperature.FromString(t); err != nil {
+				return fmt.Errorf("crop %s: %v", crop.Name, err)
+			}
+		}
+		if parseTemperature(crop.Cap).Get(Temperature.Celsius) < parseTemperature(crop.Base).Get(Temperature.Celsius) {
+			return fmt.Errorf("crop %s: cap %s is below base %s", crop.Name, crop.Cap, crop.Base)
+		}
+	}
+	return nil
+}
+
+func parseTemperature(s string) Temperature.Temperature {
+	t, _ := Temperature.FromString(s)
+	return t
+}
+
+// Heating returns the heating degree day base temperature
+func (d DegreeDays) Heating() Temperature.Temperature {
+	return parseTemperature(d.HeatingBase)
+}
+
+// Cooling returns the cooling degree day base temperature
+func (d DegreeDays) Cooling() Temperature.Temperature {
+	return parseTemperature(d.CoolingBase)
+}
+
+func (a Alerts) validate() error {
+	if d, err := time.ParseDuration(a.Interval); err != nil || d <= 0 {
+		return fmt.Errorf("invalid interval %q", a.Interval)
+	}
+	names := map[string]bool{}
+	for _, r := range a.Rules {
+		if r.Name == "" || names[r.Name] {
+			return fmt.Errorf("rule %q: a unique name is required", r.Name)
+		}
+		names[r.Name] = true
+		if !metricName.MatchString(r.Metric) {
+			return fmt.Errorf("rule %s: invalid metric %q", r.Name, r.Metric)
+		}
+		switch r.Op {
+		case "<", "<=", ">", ">=", "==", "!=":
+		default:
+			return fmt.Errorf("rule %s: unknown op %q", r.Name, r.Op)
+		}
+		if r.Hysteresis < 0 {
+			return fmt.Errorf("rule %s: hysteresis cannot be negative", r.Name)
+		}
+		if _, err := time.ParseDuration(r.For); r.For != "" && err != nil {
+			return fmt.Errorf("rule %s: invalid for %q", r.Name, r.For)
+		}
+		for label := range r.Labels {
+			if !labelName.MatchString(label) {
+				return fmt.Errorf("rule %s: invalid label name %q", r.Name, label)
+			}
+		}
+	}
+	for _, w := range a.Webhooks {
+		if w.URL == "" {
+			return fmt.Errorf("webhook url is required")
+		}
+		if w.Format != "" && w.Format != "json" && w.Format != "alertmanager" {
+			return fmt.Errorf("webhook %s: unknown format %q, expected json or alertmanager", w.URL, w.Format)
+		}
+	}
+	return nil
+}
+
+func (f Frost) validate() error {
+	if d, err := time.ParseDuration(f.Horizon); err != nil || d <= 0 {
+		return fmt.Errorf("invalid horizon %q", f.Horizon)
+	}
+	for _, source := range f.Ground {
+		if !strings.HasPrefix(source, "th") || !ecowittSensor(source) {
+			return fmt.Errorf("unknown ground sensor %q, expected th1-8", source)
+		}
+	}
+	return nil
+}
+
+// Hours returns how far ahead the minimum temperature is projected
+func (f Frost) Hours() float64 {
+	d, _ := time.ParseDuration(f.Horizon)
+	return d.Hours()
+}
+
+func (m MQTT) validate() error {
+	if m.Broker == "" {
+		return nil
+	}
+	if !strings.Contains(m.Broker, "://") {
+		return fmt.Errorf("invalid broker %q, expected tcp://host:port or mqtts://host:port", m.Broker)
+	}
+	if strings.ContainsAny(m.StateTopic+m.ValueTopic, "+#") {
+		return fmt.Errorf("state_topic and value_topic cannot contain wildcards")
+	}
+	if m.Password != "" && m.Username == "" {
+		return fmt.Errorf("password requires a username")
+	}
+	for _, sub := range m.Subscriptions {
+		if sub.Topic == "" || sub.Sensor == "" {
+			return fmt.Errorf("subscriptions: topic and sensor are required")
+		}
+		if sub.Station != "ecowitt" && sub.Station != "airgradient" {
+			return fmt.Errorf("subscription %s: unknown station %q", sub.Topic, sub.Station)
+		}
+		if sub.Station == "ecowitt" && !ecowittSensor(sub.Sensor) {
+			return fmt.Errorf("subscription %s: unknown ecowitt sensor %q, expected gateway, outdoor, th1-8 or soil1-8", sub.Topic, sub.Sensor)
+		}
+		if len(sub.Fields) == 0 {
+			return fmt.Errorf("subscription %s: no fields", sub.Topic)
+		}
+		for _, f := range sub.Fields {
+			if _, err := sub.Target(f); err != nil {
+				return fmt.Errorf("subscription %s: %v", sub.Topic, err)
+			}
+		}
+		// readings received are published again, a subscription to them would ingest its own
+		// messages forever
+		for _, topic := range []string{m.StateTopic, m.ValueTopic} {
+			if topic != "" && overlaps(sub.Topic, topic) {
+				return fmt.Errorf("subscription %s: overlaps the published topic %s", sub.Topic, topic)
+			}
+		}
+	}
+	return nil
+}
+
+// ecowittSensor reports whether an MQTT subscription can be ingested as the sensor, the
+// channels of th and soil are 1 to 8
+func ecowittSensor(name string) bool {
+	if name == "gateway" || name == "outdoor" {
+		return true
+	}
+	for _, prefix := range []string{"th", "soil"} {
+		n, err := strconv.Atoi(strings.TrimPrefix(name, prefix))
+		if err == nil && n >= 1 && n <= 8 && name == fmt.Sprintf("%s%d", prefix, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlaps reports whether a subscription filter matches any topic published from a template,
+// where {station}, {sensor} and {field} may be any name
+func overlaps(filter string, template string) bool {
+	f := strings.Split(filter, "/")
+	t := strings.Split(template, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) {
+			return false
+		}
+		if level == "+" {
+			continue
+		}
+		pattern := regexp.QuoteMeta(t[i])
+		for _, name := range []string{"{station}", "{sensor}", "{field}"} {
+			pattern = strings.ReplaceAll(pattern, regexp.QuoteMeta(name), ".*")
+		}
+		if !regexp.MustCompile("^" + pattern + "$").MatchString(level) {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
+
+// Target is the report field a reading received over MQTT is ingested as
+type Target struct {
+	Field   string // may include %d for the channel
+	Unit    string // the unit the station reports in, empty for readings without units
+	Integer bool
+}
+
+// ecowittTargets are the report fields of each ecowitt sensor's readings, th and soil for every
+// channel
+var ecowittTargets = map[string]map[string]Target{
+	"gateway": {
+		"temperature":       {"tempinf", "°F", false},
+		"humidity":          {"humidityin", "", true},
+		"pressure_relative": {"baromrelin", "inHg", false},
+		"pressure_absolute": {"baromabsin", "inHg", false},
+	},
+	"outdoor": {
+		"temperature":     {"tempf", "°F", false},
+		"humidity":        {"humidity", "", true},
+		"wind_speed":      {"windspeedmph", "mph", false},
+		"wind_gust":       {"windgustmph", "mph", false},
+		"wind_direction":  {"winddir", "", true},
+		"solar_radiation": {"solarradiation", "", false},
+		"uv":              {"uv", "", true},
+		"rain_rate":       {"rainratein", "in", false},
+		"rain_event":      {"eventrainin", "in", false},
+		"rain_hourly":     {"hourlyrainin", "in", false},
+		"rain_daily":      {"dailyrainin", "in", false},
+		"rain_weekly":     {"weeklyrainin", "in", false},
+		"rain_monthly":    {"monthlyrainin", "in", false},
+		"rain_yearly":     {"yearlyrainin", "in", false},
+		"rain_total":      {"totalrainin", "in", false},
+	},
+	"th": {
+		"temperature": {"temp%df", "°F", false},
+		"humidity":    {"humidity%d", "", true},
+	},
+	"soil": {
+		"moisture": {"soilmoisture%d", "", true},
+	},
+}
+
+var airgradientTargets = map[string]Target{
+	"temperature": {"atmp", "°C", false},
+	"humidity":    {"rhum", "", true},
+	"co2":         {"rco2", "", true},
+	"pm2_5":       {"pm02", "", true},
+	"rssi":        {"wifi", "", true},
+}
+
+// Target returns the report field a mapping of the subscription is ingested as
+func (sub Subscription) Target(f FieldMapping) (Target, error) {
+	if sub.Station == "airgradient" {
+		if t, ok := airgradientTargets[f.Quantity]; ok {
+			return t, nil
+		}
+		return Target{}, fmt.Errorf("unknown airgradient quantity %q", f.Quantity)
+	}
+
+	kind, channel := sub.Sensor, 0
+	for _, prefix := range []string{"th", "soil"} {
+		if n, err := strconv.Atoi(strings.TrimPrefix(sub.Sensor, prefix)); strings.HasPrefix(sub.Sensor, prefix) && err == nil {
+			kind, channel = prefix, n
+		}
+	}
+	t, ok := ecowittTargets[kind][f.Quantity]
+	if !ok {
+		return Target{}, fmt.Errorf("unknown quantity %q for ecowitt %s", f.Quantity, sub.Sensor)
+	}
+	if channel > 0 {
+		t.Field = fmt.Sprintf(t.Field, channel)
+	}
+	return t, nil
+}
+
+func (i Influx) validate() error {
+	if i.URL == "" {
+		return nil
+	}
+	switch {
+	case i.Version == 1 && i.Database == "":
+		return fmt.Errorf("database is required for version 1")
+	case i.Version == 2 && (i.Org == "" || i.Bucket == ""):
+		return fmt.Errorf("org and bucket are required for version 2")
+	case i.Version != 1 && i.Version != 2:
+		return fmt.Errorf("unknown version %d, expected 1 or 2", i.Version)
+	}
+	if d, err := time.ParseDuration(i.FlushInterval); err != nil || d <= 0 {
+		return fmt.Errorf("invalid flush_interval %q", i.FlushInterval)
+	}
+	if i.BatchSize <= 0 || i.BufferSize < i.BatchSize {
+		return fmt.Errorf("batch_size must be greater than 0 and no more than buffer_size")
+	}
+	if i.Measurement == "" {
+		return fmt.Errorf("measurement is required")
+	}
+	return nil
+}
+
+// Interval returns the longest a line waits to be sent
+func (i Influx) Interval() time.Duration {
+	d, _ := time.ParseDuration(i.FlushInterval)
+	return d
+}
+
+func (rw RemoteWrite) validate() error {
+	if rw.URL == "" {
+		return nil
+	}
+	if rw.BearerToken != "" && rw.Username != "" {
+		return fmt.Errorf("only one of bearer_token and username can be given")
+	}
+	if d, err := time.ParseDuration(rw.FlushInterval); err != nil || d <= 0 {
+		return fmt.Errorf("invalid flush_interval %q", rw.FlushInterval)
+	}
+	if rw.BatchSize <= 0 || rw.BufferSize < rw.BatchSize {
+		return fmt.Errorf("batch_size must be greater than 0 and no more than buffer_size")
+	}
+	for name := range rw.Labels {
+		if !labelName.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	return nil
+}
+
+// Interval returns the longest a sample waits to be sent
+func (rw RemoteWrite) Interval() time.Duration {
+	d, _ := time.ParseDuration(rw.FlushInterval)
+	return d
+}
+
+func (o OTLP) validate() error {
+	if o.Endpoint == "" {
+		return nil
+	}
+	if u, err := url.Parse(o.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid endpoint %q, expected http:// or https://", o.Endpoint)
+	}
+	return nil
+}
+
+func (g Graphite) validate() error {
+	if g.Address == "" {
+		return nil
+	}
+	if g.Protocol != "tcp" && g.Protocol != "udp" {
+		return fmt.Errorf("unknown protocol %q, expected tcp or udp", g.Protocol)
+	}
+	return validatePath(g.Address, g.Template)
+}
+
+func (s StatsD) validate() error {
+	if s.Address == "" {
+		return nil
+	}
+	return validatePath(s.Address, s.Template)
+}
+
+// validatePath checks the address and path template shared by Graphite and StatsD
+func validatePath(address string, template string) error {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return fmt.Errorf("invalid address %q, expected host:port", address)
+	}
+	if !strings.Contains(template, "{field}") {
+		return fmt.Errorf("template %q must include {field}", template)
+	}
+	return nil
+}
+
+func (u Upload) validate() error {
+	limit, ok := uploadLimits[u.Service]
+	if !ok {
+		return fmt.Errorf("unknown service %q, expected wunderground, windy, pwsweather, wow or openweathermap", u.Service)
+	}
+	if u.Key == "" || (u.Station == "" && u.Service != "windy") {
+		return fmt.Errorf("%s: station and key are required", u.Service)
+	}
+	if u.Interval != "" {
+		if d, err := time.ParseDuration(u.Interval); err != nil || d < limit {
+			return fmt.Errorf("%s: invalid interval %q, at least %s", u.Service, u.Interval, limit)
+		}
+	}
+	if u.URL != "" {
+		if parsed, err := url.Parse(u.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("%s: invalid url %q, expected http:// or https://", u.Service, u.URL)
+		}
+	}
+	return nil
+}
+
+// Every returns the shortest time between uploads
+func (u Upload) Every() time.Duration {
+	if d, err := time.ParseDuration(u.Interval); err == nil {
+		return d
+	}
+	return uploadLimits[u.Service]
+}
+
+func (r Relay) validate() error {
+	if u, err := url.Parse(r.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url %q, expected http:// or https://", r.URL)
+	}
+	if r.Passkey != "" && r.StripPasskey {
+		return fmt.Errorf("%s: only one of passkey and strip_passkey can be given", r.URL)
+	}
+	return nil
+}
+
+func (c CWOP) validate() error {
+	if c.Callsign == "" {
+		return nil
+	}
+	if !callsign.MatchString(c.Callsign) {
+		return fmt.Errorf("invalid callsign %q", c.Callsign)
+	}
+	if Config.Station.Latitude == 0 && Config.Station.Longitude == 0 {
+		return fmt.Errorf("the station's latitude and longitude are required")
+	}
+	if _, _, err := net.SplitHostPort(c.Server); err != nil {
+		return fmt.Errorf("invalid server %q, expected host:port", c.Server)
+	}
+	if d, err := time.ParseDuration(c.Interval); err != nil || d < 5*time.Minute || d > 10*time.Minute {
+		return fmt.Errorf("invalid interval %q, expected 5 to 10 minutes", c.Interval)
+	}
+	return nil
+}
+
+// Every returns the time between packets
+func (c CWOP) Every() time.Duration {
+	d, _ := time.ParseDuration(c.Interval)
+	return d
+}
+
+// Wait returns the time since the last nearby strike before it is all clear
+func (l Lightning) Wait() time.Duration {
+	d, _ := time.ParseDuration(l.AllClear)
+	return d
+}
+
+// Every returns how often the alert rules are evaluated
+func (a Alerts) Every() time.Duration {
+	d, _ := time.ParseDuration(a.Interval)
+	return d
+}
+
+// Duration returns how long the condition must hold before the alert fires
+func (r AlertRule) Duration() time.Duration {
+	d, _ := time.ParseDuration(r.For)
+	return d
+}
+
+func (z Zone) validate() error {
+	if z.Name == "" {
+		return fmt.Errorf("zone name is required")
+	}
+	if z.SoilChannel < 1 || z.SoilChannel > 8 {
+		return fmt.Errorf("zone %s: invalid soil_channel %d, expected 1 to 8", z.Name, z.SoilChannel)
+	}
+	if z.TargetMin >= z.TargetMax {
+		return fmt.Errorf("zone %s: target_min must be less than target_max", z.Name)
+	}
+	if z.RootDepth <= 0 || z.ApplicationRate <= 0 {
+		return fmt.Errorf("zone %s: root_depth and application_rate are required", z.Name)
+	}
+	return nil
+}
+
+func (c SoilCalibration) validate() error {
+	if c.Channel < 1 || c.Channel > 8 {
+		return fmt.Errorf("invalid channel %d, expected 1 to 8", c.Channel)
+	}
+	switch {
+	case len(c.Points) == 0 && c.DryAD == c.WetAD:
+		return fmt.Errorf("channel %d: dry_ad and wet_ad must differ", c.Channel)
+	case len(c.Points) == 1:
+		return fmt.Errorf("channel %d needs dry_ad and wet_ad or at least two points", c.Channel)
+	}
+	for i := 1; i < len(c.Points); i++ {
+		if c.Points[i].Raw <= c.Points[i-1].Raw {
+			return fmt.Errorf("channel %d: points must be in increasing order of raw, without repeats", c.Channel)
+		}
+	}
+	return nil
+}
+
+// Curve returns the calibration curve in order of raw reading
+func (c SoilCalibration) Curve() Moisture.Curve {
+	curve := Moisture.Curve(c.Points)
+	if len(curve) == 0 {
+		curve = Moisture.Curve{{Raw: c.DryAD, Moisture: 0}, {Raw: c.WetAD, Moisture: 100}}
+	}
+
+	sorted := append(Moisture.Curve{}, curve...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Raw < sorted[j].Raw })
+	return sorted
+}
+
+// SoilCurve returns the calibration for a soil moisture channel, false if it is not calibrated
+func SoilCurve(channel int) (Moisture.Curve, bool) {
+	for _, c := range Config.SoilCalibration {
+		if c.Channel == channel {
+			return c.Curve(), true
+		}
+	}
+	return nil, false
+}
+
+func (c *Calibration) validate() error {
+	if c.Station != "ecowitt" && c.Station != "airgradient" {
+		return fmt.Errorf("unknown station %q", c.Station)
+	}
+	switch c.Quantity {
+	case "temperature", "pressure", "wind", "rain", "humidity", "solar", "uv", "co2", "pm":
+	default:
+		return fmt.Errorf("unknown quantity %q", c.Quantity)
+	}
+	if c.Gain == 0 {
+		c.Gain = 1.0
+	}
+	if c.Offset == "" {
+		return nil
+	}
+
+	var err error
+	switch c.Quantity {
+	case "temperature":
+		_, err = Temperature.FromString(c.Offset)
+	case "pressure":
+		_, err = Pressure.FromString(c.Offset)
+	case "wind":
+		_, err = Velocity.FromString(c.Offset)
+	case "rain":
+		_, err = Rainfall.FromString(c.Offset)
+	default:
+		_, err = c.Value()
+	}
+	return err
+}
+
+// Value returns the offset of a quantity without units, any unit written after the value is ignored
+func (c Calibration) Value() (float64, error) {
+	fields := strings.Fields(c.Offset)
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	value, err := strconv.ParseFloat(strings.TrimRight(fields[0], "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s offset %q", c.Quantity, c.Offset)
+	}
+	return value, nil
+}
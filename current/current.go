@@ -0,0 +1,334 @@
+package current
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"neverending.dev/weather/airgradient"
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/ecowitt"
+	"neverending.dev/weather/sensors"
+)
+
+/*
+ * The current conditions of each station as JSON, grouped into the gateway, the outdoor array,
+ * the temperature/humidity and soil channels, the lightning sensor and air quality. Each value is
+ * paired with its unit, in the units asked for, and each sensor has the time its readings were
+ * received and whether they are stale, not received within the sensor timeout.
+ */
+
+// Value is a reading and its unit
+type Value struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit,omitempty"`
+}
+
+// Sensor is common to every section
+type Sensor struct {
+	Sensor   string     `json:"sensor"`
+	Name     string     `json:"name"`
+	Zone     string     `json:"zone,omitempty"`
+	Received *time.Time `json:"received,omitempty"`
+	Stale    bool       `json:"stale"`
+	Battery  string     `json:"battery,omitempty"` // ok, low or critical
+}
+
+type Gateway struct {
+	Sensor
+	Temperature      *Value `json:"temperature,omitempty"`
+	Humidity         *Value `json:"humidity,omitempty"`
+	PressureRelative *Value `json:"pressure_relative,omitempty"`
+	PressureAbsolute *Value `json:"pressure_absolute,omitempty"`
+}
+
+type Outdoor struct {
+	Sensor
+	Temperature    *Value `json:"temperature,omitempty"`
+	Humidity       *Value `json:"humidity,omitempty"`
+	WindSpeed      *Value `json:"wind_speed,omitempty"`
+	WindGust       *Value `json:"wind_gust,omitempty"`
+	WindDirection  *Value `json:"wind_direction,omitempty"`
+	SolarRadiation *Value `json:"solar_radiation,omitempty"`
+	UV             *Value `json:"uv,omitempty"`
+	RainRate       *Value `json:"rain_rate,omitempty"`
+	RainEvent      *Value `json:"rain_event,omitempty"`
+	RainHourly     *Value `json:"rain_hourly,omitempty"`
+	RainDaily      *Value `json:"rain_daily,omitempty"`
+	RainWeekly     *Value `json:"rain_weekly,omitempty"`
+	RainMonthly    *Value `json:"rain_monthly,omitempty"`
+	RainYearly     *Value `json:"rain_yearly,omitempty"`
+	RainTotal      *Value `json:"rain_total,omitempty"`
+}
+
+// Channel is a temperature/humidity sensor
+type Channel struct {
+	Sensor
+	Channel     int    `json:"channel"`
+	Temperature *Value `json:"temperature,omitempty"`
+	Humidity    *Value `json:"humidity,omitempty"`
+}
+
+// Soil is a soil moisture sensor
+type Soil struct {
+	Sensor
+	Channel  int    `json:"channel"`
+	Moisture *Value `json:"moisture,omitempty"`
+}
+
+type Lightning struct {
+	Sensor
+	Distance   *Value     `json:"distance,omitempty"` // of the last strike
+	Strikes    *Value     `json:"strikes,omitempty"`  // today
+	LastStrike *time.Time `json:"last_strike,omitempty"`
+}
+
+type AirQuality struct {
+	Sensor
+	Temperature *Value `json:"temperature,omitempty"`
+	Humidity    *Value `json:"humidity,omitempty"`
+	CO2         *Value `json:"co2,omitempty"`
+	PM2dot5     *Value `json:"pm2_5,omitempty"`
+	Signal      *Value `json:"rssi,omitempty"`
+}
+
+// Station is the current conditions of a station, with the sections it reports
+type Station struct {
+	Station  string     `json:"station"` // ecowitt or airgradient
+	ID       string     `json:"id,omitempty"`
+	Model    string     `json:"model,omitempty"`
+	Received *time.Time `json:"received,omitempty"`
+	Stale    bool       `json:"stale"`
+
+	Gateway    *Gateway    `json:"gateway,omitempty"`
+	Outdoor    *Outdoor    `json:"outdoor,omitempty"`
+	Channels   []Channel   `json:"channels,omitempty"`
+	Soil       []Soil      `json:"soil,omitempty"`
+	Lightning  *Lightning  `json:"lightning,omitempty"`
+	AirQuality *AirQuality `json:"air_quality,omitempty"`
+}
+
+// Conditions is the response of the current conditions API
+type Conditions struct {
+	Time     time.Time `json:"time"`
+	Units    Units     `json:"units"`
+	Stations []Station `json:"stations"`
+}
+
+// Update is the current conditions of a single station
+type Update struct {
+	Time  time.Time `json:"time"`
+	Units Units     `json:"units"`
+	Station
+}
+
+// Matches reports whether id names the station, either ecowitt or airgradient or its own ID
+func (s Station) Matches(id string) bool {
+	return strings.EqualFold(id, s.Station) || (s.ID != "" && strings.EqualFold(id, s.ID))
+}
+
+// value returns a reading rounded to 2 decimal places, nil if it has not been reported
+func value(v float64, unit string) *Value {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &Value{Value: math.Round(v*100) / 100, Unit: unit}
+}
+
+func describe(station string, sensor string) Sensor {
+	d := sensors.Describe(station, sensor, 0, true)
+	return Sensor{
+		Sensor:   sensor,
+		Name:     d.Name,
+		Zone:     d.Zone,
+		Received: d.Seen,
+		Stale:    !d.Up,
+		Battery:  d.Battery,
+	}
+}
+
+// Ecowitt returns the current conditions of the ecowitt station, false if it has not reported
+func Ecowitt(u Units) (Station, bool) {
+	ws := ecowitt.Snapshot()
+	if ws.Status != ecowitt.Ready {
+		return Station{}, false
+	}
+	seen := func(sensor string) bool {
+		_, ok := ws.LastSeen[sensor]
+		return ok
+	}
+
+	s := Station{
+		Station: "ecowitt",
+		ID:      config.Config.Station.ID, // the PASSKEY authenticates the gateway, it is not shown
+		Model:   ws.Gateway.Model,
+	}
+
+	if seen("gateway") {
+		s.Gateway = &Gateway{
+			Sensor:           describe("ecowitt", "gateway"),
+			Temperature:      value(ws.Gateway.Temperature.Get(u.Temperature), u.Temperature.String()),
+			Humidity:         value(float64(ws.Gateway.Humidity.Get()), "%"),
+			PressureRelative: value(ws.Gateway.PressureRelative.Get(u.Pressure), u.Pressure.String()),
+			PressureAbsolute: value(ws.Gateway.PressureAbsolute.Get(u.Pressure), u.Pressure.String()),
+		}
+	}
+
+	if seen("outdoor") {
+		o := ws.Outdoor
+		rain := u.Rain.String()
+		s.Outdoor = &Outdoor{
+			Sensor:         describe("ecowitt", "outdoor"),
+			Temperature:    value(o.Temperature.Get(u.Temperature), u.Temperature.String()),
+			Humidity:       value(float64(o.Humidity.Get()), "%"),
+			WindSpeed:      value(o.WindSpeed.Get(u.Wind), u.Wind.String()),
+			WindGust:       value(o.WindGust.Get(u.Wind), u.Wind.String()),
+			WindDirection:  value(float64(o.WindDirection), "°"),
+			SolarRadiation: value(o.SolarRadiation, "W/m²"),
+			UV:             value(float64(o.UV), ""),
+			RainRate:       value(o.RainRate.Get(u.Rain), rain+"/h"),
+			RainEvent:      value(o.RainEvent.Get(u.Rain), rain),
+			RainHourly:     value(o.RainHourly.Get(u.Rain), rain),
+			RainDaily:      value(o.RainDaily.Get(u.Rain), rain),
+			RainWeekly:     value(o.RainWeekly.Get(u.Rain), rain),
+			RainMonthly:    value(o.RainMonthly.Get(u.Rain), rain),
+			RainYearly:     value(o.RainYearly.Get(u.Rain), rain),
+			RainTotal:      value(o.RainTotal.Get(u.Rain), rain),
+		}
+	}
+
+	for _, sensor := range ws.TemperatureHumidity {
+		name := fmt.Sprintf("th%d", sensor.ID)
+		s.Channels = append(s.Channels, Channel{
+			Sensor:      describe("ecowitt", name),
+			Channel:     sensor.ID,
+			Temperature: value(sensor.Temperature.Get(u.Temperature), u.Temperature.String()),
+			Humidity:    value(float64(sensor.Humidity.Get()), "%"),
+		})
+	}
+
+	for _, sensor := range ws.SoilMoisture {
+		name := fmt.Sprintf("soil%d", sensor.ID)
+		s.Soil = append(s.Soil, Soil{
+			Sensor:   describe("ecowitt", name),
+			Channel:  sensor.ID,
+			Moisture: value(float64(sensor.Moisture.Get()), "%"),
+		})
+	}
+
+	if seen("lightning") {
+		s.Lightning = &Lightning{
+			Sensor:   describe("ecowitt", "lightning"),
+			Distance: value(float64(ws.Lightning.Distance), "km"),
+			Strikes:  value(float64(ws.Lightning.Count), ""),
+		}
+		if ws.Lightning.Time > 0 {
+			t := time.Unix(int64(ws.Lightning.Time), 0).UTC()
+			s.Lightning.LastStrike = &t
+		}
+	}
+
+	latest := time.Time{}
+	for _, t := range ws.LastSeen {
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	if !latest.IsZero() {
+		s.Received = &latest
+	}
+	s.Stale = time.Since(latest) >= config.SensorTimeout()
+	return s, true
+}
+
+// AirGradient returns the current conditions of the AirGradient, false if it has not reported
+func AirGradient(u Units) (Station, bool) {
+	ag := airgradient.Snapshot()
+	if ag.Status != airgradient.Ready {
+		return Station{}, false
+	}
+
+	s := Station{
+		Station: "airgradient",
+		ID:      ag.ID,
+		Model:   "AirGradient",
+		AirQuality: &AirQuality{
+			Sensor:      describe("airgradient", ag.ID),
+			Temperature: value(ag.Temperature.Get(u.Temperature), u.Temperature.String()),
+			Humidity:    value(float64(ag.Humidity.Get()), "%"),
+			CO2:         value(float64(ag.CO2), "ppm"),
+			PM2dot5:     value(float64(ag.PM2dot5), "µg/m³"),
+			Signal:      value(float64(ag.SignalStrength), "dBm"),
+		},
+	}
+	s.Received = s.AirQuality.Received
+	s.Stale = s.AirQuality.Stale
+	return s, true
+}
+
+// Of returns the current conditions of a station by name, false if it has not reported
+func Of(station string, u Units) (Station, bool) {
+	switch station {
+	case "ecowitt":
+		return Ecowitt(u)
+	case "airgradient":
+		return AirGradient(u)
+	}
+	return Station{}, false
+}
+
+// Current returns the current conditions of every station that has reported
+func Current(u Units) Conditions {
+	c := Conditions{Time: time.Now().UTC(), Units: u, Stations: []Station{}}
+	if s, ok := Ecowitt(u); ok {
+		c.Stations = append(c.Stations, s)
+	}
+	if s, ok := AirGradient(u); ok {
+		c.Stations = append(c.Stations, s)
+	}
+	return c
+}
+
+func write(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+// Handler serves the current conditions of every station
+func Handler(w http.ResponseWriter, r *http.Request) {
+	u, err := ParseUnits(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	write(w, Current(u))
+}
+
+// StationHandler serves the current conditions of one station at /api/v1/stations/{id}/current,
+// where the ID is either the station's name, ecowitt or airgradient, or its own ID
+func StationHandler(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/stations/"), "/"), "/")
+	if len(parts) != 2 || parts[1] != "current" {
+		http.NotFound(w, r)
+		return
+	}
+
+	u, err := ParseUnits(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	for _, s := range Current(u).Stations {
+		if s.Matches(parts[0]) {
+			write(w, Update{Time: time.Now().UTC(), Units: u, Station: s})
+			return
+		}
+	}
+	http.Error(w, fmt.Sprintf("station %s has not reported", parts[0]), 404)
+}
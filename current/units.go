@@ -0,0 +1,83 @@
+package current
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/measurement/Pressure"
+	"neverending.dev/weather/measurement/Rainfall"
+	"neverending.dev/weather/measurement/Temperature"
+	"neverending.dev/weather/measurement/Velocity"
+)
+
+// Units are the units conditions are given in
+type Units struct {
+	Temperature Temperature.Unit
+	Pressure    Pressure.Unit
+	Wind        Velocity.Unit
+	Rain        Rainfall.Unit
+}
+
+// systems are the unit systems that can be asked for with ?units=
+var systems = map[string]Units{
+	"metric":   {Temperature.Celsius, Pressure.Hectopascal, Velocity.KilometresPerHour, Rainfall.Millimetre},
+	"imperial": {Temperature.Farenheit, Pressure.InchOfMercury, Velocity.MilesPerHour, Rainfall.Inch},
+	"si":       {Temperature.Kelvin, Pressure.Pascal, Velocity.MetresPerSecond, Rainfall.Millimetre},
+}
+
+// ParseUnits returns the units asked for in a query, the configured units unless a system is
+// given with units=metric, imperial or si. Each quantity can be overridden with temperature=,
+// pressure=, wind= or rain=, e.g. ?units=metric&wind=m/s.
+func ParseUnits(query url.Values) (Units, error) {
+	configured := config.Config.Units
+	units := Units{
+		Temperature: configured.TemperatureUnit(),
+		Pressure:    configured.PressureUnit(),
+		Wind:        configured.WindUnit(),
+		Rain:        configured.RainUnit(),
+	}
+
+	if system := query.Get("units"); system != "" {
+		u, ok := systems[system]
+		if !ok {
+			return units, fmt.Errorf("unknown units %q, expected metric, imperial or si", system)
+		}
+		units = u
+	}
+
+	var err error
+	if s := query.Get("temperature"); s != "" {
+		if units.Temperature, err = Temperature.Parse(s); err != nil {
+			return units, err
+		}
+	}
+	if s := query.Get("pressure"); s != "" {
+		if units.Pressure, err = Pressure.Parse(s); err != nil {
+			return units, err
+		}
+	}
+	if s := query.Get("wind"); s != "" {
+		if units.Wind, err = Velocity.Parse(s); err != nil {
+			return units, err
+		}
+	}
+	if s := query.Get("rain"); s != "" {
+		if units.Rain, err = Rainfall.Parse(s); err != nil {
+			return units, err
+		}
+	}
+	return units, nil
+}
+
+// MarshalJSON gives the units by their symbols
+func (u Units) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{
+		"temperature": u.Temperature.String(),
+		"pressure":    u.Pressure.String(),
+		"wind":        u.Wind.String(),
+		"rain":        u.Rain.String(),
+		"rain_rate":   u.Rain.String() + "/h",
+	})
+}
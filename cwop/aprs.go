@@ -0,0 +1,98 @@
+package cwop
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"neverending.dev/weather/measurement/Rainfall"
+	"neverending.dev/weather/measurement/Temperature"
+	"neverending.dev/weather/measurement/Velocity"
+)
+
+/*
+ * APRS complete weather reports with a timestamp and position:
+ *
+ *	CALLSIGN>APRS,TCPIP*:@DDHHMMzDDMM.mmN/DDDMM.mmW_ddd/sssgGGGtTTTrRRRPPPPhHHbBBBBBLLLL
+ *
+ * ddd wind direction in degrees, sss wind speed and gGGG gust in mph, tTTT temperature in °F,
+ * rRRR rain over the last hour and PPPP since midnight in hundredths of an inch, hHH humidity
+ * with 00 for 100%, bBBBBB sea level pressure in tenths of hPa and LLLL solar radiation in W/m²,
+ * lLLL above 999. Wind and temperature are given as dots when they are not known, the other
+ * readings are left out. pPPP, rain over the last 24 hours, is never sent as the gateway
+ * reports no rolling 24 hour total, only totals for the hour, day, week, month and year.
+ *
+ * APRS Protocol Reference 1.0.1, Chapter 12 Weather Reports
+ */
+
+// field formats a reading as a whole number of width digits, dots when it was not reported
+func field(value float64, width int) string {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return strings.Repeat(".", width)
+	}
+	v := int(math.Round(value))
+	if v < 0 {
+		return fmt.Sprintf("-%0*d", width-1, -v)
+	}
+	return fmt.Sprintf("%0*d", width, v)
+}
+
+// coordinate formats decimal degrees as degrees and minutes to hundredths, e.g. 4903.50N
+func coordinate(degrees float64, width int, positive byte, negative byte) string {
+	hemisphere := positive
+	if degrees < 0 {
+		hemisphere = negative
+	}
+	minutes := math.Round(math.Abs(degrees)*60*100) / 100
+	d := int(minutes / 60)
+	return fmt.Sprintf("%0*d%05.2f%c", width, d, minutes-float64(d*60), hemisphere)
+}
+
+func mph(kmh float64) float64 {
+	return Velocity.New(kmh, Velocity.KilometresPerHour).Get(Velocity.MilesPerHour)
+}
+
+// hundredths converts rain to hundredths of an inch, at most the 999 that fit in the packet
+func hundredths(mm float64) float64 {
+	return math.Min(Rainfall.New(mm, Rainfall.Millimetre).Get(Rainfall.Inch)*100, 999)
+}
+
+// Packet returns the weather report of readings at a time, the readings keyed by their field
+// name in the units of the readings, e.g. wind_speed in km/h
+func Packet(callsign string, latitude float64, longitude float64, t time.Time, readings map[string]float64) string {
+	get := func(name string) float64 {
+		if v, ok := readings[name]; ok {
+			return v
+		}
+		return math.NaN()
+	}
+
+	packet := fmt.Sprintf("%s>APRS,TCPIP*:@%sz%s/%s_", callsign, t.UTC().Format("021504"),
+		coordinate(latitude, 2, 'N', 'S'), coordinate(longitude, 3, 'E', 'W'))
+
+	packet += field(get("wind_direction"), 3) + "/" + field(mph(get("wind_speed")), 3)
+	packet += "g" + field(mph(get("wind_gust")), 3)
+	packet += "t" + field(Temperature.New(get("temperature"), Temperature.Celsius).Get(Temperature.Farenheit), 3)
+
+	if v := hundredths(get("rain_hourly")); !math.IsNaN(v) {
+		packet += "r" + field(v, 3)
+	}
+	if v := hundredths(get("rain_daily")); !math.IsNaN(v) {
+		packet += "P" + field(v, 3)
+	}
+	if v := get("humidity"); !math.IsNaN(v) {
+		packet += "h" + field(math.Mod(math.Max(1, math.Min(100, v)), 100), 2)
+	}
+	if v := get("pressure_relative"); !math.IsNaN(v) {
+		packet += "b" + field(v*10, 5)
+	}
+	if v := get("solar_radiation"); !math.IsNaN(v) {
+		if v < 1000 {
+			packet += "L" + field(v, 3)
+		} else {
+			packet += "l" + field(math.Min(v-1000, 999), 3)
+		}
+	}
+	return packet
+}
@@ -0,0 +1,141 @@
+package cwop
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/ecowitt"
+	"neverending.dev/weather/metrics"
+	"neverending.dev/weather/readings"
+)
+
+/*
+ * Sends the outdoor readings to the Citizen Weather Observer Program every 5 to 10 minutes, as
+ * APRS weather reports to an APRS-IS server. Each report connects, logs in with the callsign and
+ * passcode, sends the packet and disconnects, as CWOP asks of stations reporting this often. No
+ * report is sent while the outdoor sensor array is not reporting, and a report that fails is not
+ * retried as the next one follows within minutes.
+ *
+ * APRS-IS login: user CALLSIGN pass PASSCODE vers SOFTWARE VERSION
+ */
+
+const timeout = 30 * time.Second
+
+var lock sync.Mutex
+var successes, failures int
+var lastSuccess time.Time
+
+// Start sends a report every interval
+func Start() {
+	if config.Config.CWOP.Callsign == "" {
+		return
+	}
+
+	go func() {
+		for range time.Tick(config.Config.CWOP.Every()) {
+			report()
+		}
+	}()
+}
+
+// current returns the readings of the outdoor sensor array and the gateway's pressure, false when
+// the outdoor sensor array has not reported within the interval
+func current() (map[string]float64, time.Time, bool) {
+	seen, ok := ecowitt.Snapshot().LastSeen["outdoor"]
+	if !ok || time.Since(seen) > config.Config.CWOP.Every() {
+		return nil, seen, false
+	}
+
+	values := map[string]float64{}
+	for _, r := range readings.Current() {
+		switch {
+		case r.Station != "ecowitt":
+		case r.Sensor == "outdoor", r.Sensor == "gateway" && r.Field == "pressure_relative":
+			values[r.Field] = r.Value
+		}
+	}
+	return values, seen, true
+}
+
+func report() {
+	values, seen, ok := current()
+	if !ok {
+		log.Printf("cwop: outdoor sensor array not reporting, skipping report")
+		return
+	}
+
+	c := config.Config.CWOP
+	packet := Packet(c.Callsign, config.Config.Station.Latitude, config.Config.Station.Longitude, seen, values)
+	err := send(c, packet)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if err != nil {
+		failures++
+		log.Printf("cwop: unable to send report: %v", err)
+		return
+	}
+	successes++
+	lastSuccess = time.Now()
+}
+
+// send logs in to the APRS-IS server and sends a packet
+func send(c config.CWOP, packet string) error {
+	conn, err := net.DialTimeout("tcp", c.Server, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	r := bufio.NewReader(conn)
+
+	// the server greets with a comment line before the login
+	if line, err := r.ReadString('\n'); err != nil {
+		return err
+	} else if !strings.HasPrefix(line, "#") {
+		return fmt.Errorf("unexpected greeting %q", strings.TrimSpace(line))
+	}
+
+	if _, err := fmt.Fprintf(conn, "user %s pass %d vers weather 1.0\r\n", c.Callsign, c.Passcode); err != nil {
+		return err
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "# logresp") {
+			continue
+		}
+		// CW stations are unverified, a callsign with its passcode should be verified
+		if c.Passcode != -1 && strings.Contains(line, "unverified") {
+			return fmt.Errorf("passcode not accepted: %s", strings.TrimSpace(line))
+		}
+		break
+	}
+
+	_, err = fmt.Fprintf(conn, "%s\r\n", packet)
+	return err
+}
+
+// Report adds the number of reports sent and failed, and the time of the last report sent
+func Report(report map[string]string) {
+	if config.Config.CWOP.Callsign == "" {
+		return
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	labels := metrics.Label("callsign", config.Config.CWOP.Callsign)
+	report[fmt.Sprintf("weather_cwop_reports_total{%s,result=\"success\"}", labels)] = fmt.Sprintf("%d", successes)
+	report[fmt.Sprintf("weather_cwop_reports_total{%s,result=\"failure\"}", labels)] = fmt.Sprintf("%d", failures)
+	if !lastSuccess.IsZero() {
+		report[fmt.Sprintf("weather_cwop_last_success_timestamp_seconds{%s}", labels)] = fmt.Sprintf("%d", lastSuccess.Unix())
+	}
+}
@@ -0,0 +1,187 @@
+package cwop
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/ecowitt"
+)
+
+// session is what an APRS-IS stand-in received from a client
+type session struct {
+	login  string
+	packet string
+	err    error
+}
+
+// server is an APRS-IS stand-in that greets each client, answers its login with logresp and
+// reads the packet that follows
+func server(t *testing.T, logresp string) (string, chan session) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	sessions := make(chan session, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				r := bufio.NewReader(conn)
+
+				s := session{}
+				defer func() { sessions <- s }()
+				fmt.Fprint(conn, "# aprsc 2.1.14-g5e22b37\r\n")
+				if s.login, s.err = r.ReadString('\n'); s.err != nil {
+					return
+				}
+				fmt.Fprint(conn, "# comment before the login response\r\n")
+				fmt.Fprintf(conn, "# logresp %s, server T2TEST\r\n", logresp)
+				s.packet, s.err = r.ReadString('\n')
+			}()
+		}
+	}()
+	return l.Addr().String(), sessions
+}
+
+func receive(t *testing.T, sessions chan session) session {
+	t.Helper()
+	select {
+	case s := <-sessions:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("no session")
+	}
+	return session{}
+}
+
+func TestPacket(t *testing.T) {
+	at := time.Date(2026, 10, 19, 17, 56, 30, 0, time.UTC)
+	tests := []struct {
+		name     string
+		readings map[string]float64
+		want     string
+	}{
+		{
+			name: "complete",
+			readings: map[string]float64{
+				"wind_direction": 200, "wind_speed": 8.05, "wind_gust": 14.48, "temperature": -20.5,
+				"rain_hourly": 2.54, "rain_daily": 7.62, "humidity": 100, "pressure_relative": 1012.5,
+				"solar_radiation": 1234,
+			},
+			want: "CW0001>APRS,TCPIP*:@191756z4903.50N/07201.75W_200/005g009t-05r010P030h00b10125l234",
+		},
+		{
+			name:     "solar below 1000",
+			readings: map[string]float64{"temperature": 21.1, "humidity": 45, "solar_radiation": 456.4},
+			want:     "CW0001>APRS,TCPIP*:@191756z4903.50N/07201.75W_.../...g...t070h45L456",
+		},
+		{
+			name:     "nothing reported",
+			readings: map[string]float64{},
+			want:     "CW0001>APRS,TCPIP*:@191756z4903.50N/07201.75W_.../...g...t...",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Packet("CW0001", 49.0583, -72.0292, at, test.readings); got != test.want {
+				t.Errorf("Packet() =\n%s, want\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSend(t *testing.T) {
+	packet := "CW0001>APRS,TCPIP*:@191756z4903.50N/07201.75W_200/005g009t-05r010P030h00b10125l234"
+
+	t.Run("unverified", func(t *testing.T) {
+		addr, sessions := server(t, "CW0001 unverified")
+		if err := send(config.CWOP{Callsign: "CW0001", Passcode: -1, Server: addr}, packet); err != nil {
+			t.Fatal(err)
+		}
+		s := receive(t, sessions)
+		if s.err != nil {
+			t.Fatal(s.err)
+		}
+		if s.login != "user CW0001 pass -1 vers weather 1.0\r\n" {
+			t.Errorf("login %q", s.login)
+		}
+		if s.packet != packet+"\r\n" {
+			t.Errorf("packet %q, want %q", s.packet, packet+"\r\n")
+		}
+	})
+
+	t.Run("verified", func(t *testing.T) {
+		addr, sessions := server(t, "N0CALL verified")
+		if err := send(config.CWOP{Callsign: "N0CALL", Passcode: 13023, Server: addr}, packet); err != nil {
+			t.Fatal(err)
+		}
+		if s := receive(t, sessions); s.login != "user N0CALL pass 13023 vers weather 1.0\r\n" || s.packet != packet+"\r\n" {
+			t.Errorf("login %q packet %q", s.login, s.packet)
+		}
+	})
+
+	t.Run("passcode rejected", func(t *testing.T) {
+		addr, sessions := server(t, "N0CALL unverified")
+		err := send(config.CWOP{Callsign: "N0CALL", Passcode: 12345, Server: addr}, packet)
+		if err == nil || !strings.Contains(err.Error(), "passcode not accepted") {
+			t.Fatalf("send() = %v, want passcode not accepted", err)
+		}
+		if s := receive(t, sessions); s.packet != "" {
+			t.Errorf("packet %q sent with a rejected passcode", s.packet)
+		}
+	})
+}
+
+func TestReport(t *testing.T) {
+	addr, sessions := server(t, "CW0001 unverified")
+	config.Config.Station.Latitude = 49.0583
+	config.Config.Station.Longitude = -72.0292
+	config.Config.CWOP = config.CWOP{Callsign: "CW0001", Passcode: -1, Server: addr, Interval: "5m"}
+
+	ecowitt.Ingest(url.Values{
+		"stationtype":    {"GW2000A_V3.1.0"},
+		"baromrelin":     {"29.900"},
+		"tempf":          {"23.0"},
+		"humidity":       {"100"},
+		"winddir":        {"200"},
+		"windspeedmph":   {"5.00"},
+		"windgustmph":    {"9.00"},
+		"hourlyrainin":   {"0.100"},
+		"dailyrainin":    {"0.300"},
+		"solarradiation": {"1234.00"},
+	}, true)
+	report()
+
+	s := receive(t, sessions)
+	if s.err != nil {
+		t.Fatal(s.err)
+	}
+	want := regexp.MustCompile(`^CW0001>APRS,TCPIP\*:@\d{6}z4903\.50N/07201\.75W_200/005g009t023r010P030h00b10125l234\r\n$`)
+	if !want.MatchString(s.packet) {
+		t.Errorf("packet %q", s.packet)
+	}
+
+	metrics := map[string]string{}
+	Report(metrics)
+	if v := metrics[`weather_cwop_reports_total{callsign="CW0001",result="success"}`]; v != "1" {
+		t.Errorf("successes %q, metrics %v", v, metrics)
+	}
+	if v := metrics[`weather_cwop_reports_total{callsign="CW0001",result="failure"}`]; v != "0" {
+		t.Errorf("failures %q", v)
+	}
+}
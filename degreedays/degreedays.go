@@ -0,0 +1,275 @@
+package degreedays
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/ecowitt"
+	"neverending.dev/weather/measurement/Temperature"
+	"neverending.dev/weather/sensors"
+)
+
+/*
+ * Heating and cooling degree days use the daily mean temperature against the base. Growing degree
+ * days use the modified method, the daily high and low are limited to the crop's base and cap
+ * before averaging. Daily totals are for the day so far and the season totals include today.
+ */
+
+// Day tracks a source's temperatures for a single day in °C
+type Day struct {
+	Date    string  `json:"date"`
+	Samples int     `json:"samples"`
+	Sum     float64 `json:"sum"`
+	High    float64 `json:"high"`
+	Low     float64 `json:"low"`
+}
+
+// Totals are degree days in °C
+type Totals struct {
+	Heating float64            `json:"heating"`
+	Cooling float64            `json:"cooling"`
+	Growing map[string]float64 `json:"growing"` // by crop name
+}
+
+// Source is the state of a single temperature source
+type Source struct {
+	Today  Day    `json:"today"`
+	Season Totals `json:"season"` // completed days since the season started
+}
+
+// State is persisted across restarts
+type State struct {
+	Season  string             `json:"season"` // date the current season started, YYYY-MM-DD
+	Sources map[string]*Source `json:"sources"`
+}
+
+type crop struct {
+	name string
+	base float64
+	cap  float64
+}
+
+var state = State{Sources: map[string]*Source{}}
+
+// lock guards state, updated from reports while the metrics read it
+var lock sync.Mutex
+var heatingBase, coolingBase float64
+var crops []crop
+
+func filename() string {
+	return filepath.Join(config.Config.DataDir, "degreedays.json")
+}
+
+// Start loads the persisted totals and begins tracking reports from the ecowitt gateway
+func Start() {
+	dd := config.Config.DegreeDays
+
+	heatingBase = dd.Heating().Get(Temperature.Celsius)
+	coolingBase = dd.Cooling().Get(Temperature.Celsius)
+
+	crops = nil
+	for _, c := range dd.Crops {
+		base, _ := Temperature.FromString(c.Base)
+		cap, _ := Temperature.FromString(c.Cap)
+		crops = append(crops, crop{name: c.Name, base: base.Get(Temperature.Celsius), cap: cap.Get(Temperature.Celsius)})
+	}
+
+	if data, err := os.ReadFile(filename()); err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			log.Printf("degreedays: unable to load %s: %v", filename(), err)
+		}
+	}
+
+	ecowitt.OnReport(Update)
+}
+
+// HeatingCooling returns the heating and cooling degree days for a mean temperature in °C
+func HeatingCooling(mean float64) (float64, float64) {
+	heating, cooling := 0.0, 0.0
+	if mean < heatingBase {
+		heating = heatingBase - mean
+	}
+	if mean > coolingBase {
+		cooling = mean - coolingBase
+	}
+	return heating, cooling
+}
+
+func limit(value float64, c crop) float64 {
+	if value < c.base {
+		return c.base
+	}
+	if value > c.cap {
+		return c.cap
+	}
+	return value
+}
+
+// growing returns the growing degree days for a crop given the day's high and low in °C
+func growing(high float64, low float64, c crop) float64 {
+	return (limit(high, c)+limit(low, c))/2 - c.base
+}
+
+// totals returns the degree days for the day so far
+func (d Day) totals() Totals {
+	t := Totals{Growing: map[string]float64{}}
+	if d.Samples == 0 {
+		return t
+	}
+	t.Heating, t.Cooling = HeatingCooling(d.Sum / float64(d.Samples))
+	for _, c := range crops {
+		t.Growing[c.name] = growing(d.High, d.Low, c)
+	}
+	return t
+}
+
+func (t *Totals) add(other Totals) {
+	t.Heating += other.Heating
+	t.Cooling += other.Cooling
+	if t.Growing == nil {
+		t.Growing = map[string]float64{}
+	}
+	for name, value := range other.Growing {
+		t.Growing[name] += value
+	}
+}
+
+// temperature returns the current reading for a source, outdoor or a temperature/humidity channel,
+// false when it has not been reported
+func temperature(source string) (float64, bool) {
+	t := math.NaN()
+	if source == "outdoor" {
+		t = ecowitt.WS.Outdoor.Temperature.Get(Temperature.Celsius)
+	} else if channel, err := strconv.Atoi(strings.TrimPrefix(source, "th")); err == nil {
+		for _, sensor := range ecowitt.WS.TemperatureHumidity {
+			if sensor.ID == channel {
+				t = sensor.Temperature.Get(Temperature.Celsius)
+			}
+		}
+	}
+	return t, !math.IsNaN(t)
+}
+
+// seasonStart returns the date of the most recent season start on or before t
+func seasonStart(t time.Time) string {
+	start, _ := time.Parse("01-02", config.Config.DegreeDays.SeasonStart)
+	season := time.Date(t.Year(), start.Month(), start.Day(), 0, 0, 0, 0, t.Location())
+	if season.After(t) {
+		season = season.AddDate(-1, 0, 0)
+	}
+	return season.Format("2006-01-02")
+}
+
+// Update adds the current readings of each source to today's totals
+func Update() {
+	t := ecowitt.WS.ObservationTime().In(config.Location())
+	date := t.Format("2006-01-02")
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if season := seasonStart(t); season != state.Season {
+		state.Season = season
+		for _, source := range state.Sources {
+			source.Season = Totals{}
+			if source.Today.Date < season {
+				source.Today = Day{}
+			}
+		}
+	}
+
+	for _, name := range config.Config.DegreeDays.Sources {
+		value, ok := temperature(name)
+		if !ok {
+			continue
+		}
+
+		source, ok := state.Sources[name]
+		if !ok {
+			source = &Source{}
+			state.Sources[name] = source
+		}
+
+		if source.Today.Date != date {
+			if source.Today.Samples > 0 {
+				source.Season.add(source.Today.totals())
+			}
+			source.Today = Day{Date: date, High: value, Low: value}
+		}
+
+		source.Today.Samples++
+		source.Today.Sum += value
+		if value > source.Today.High {
+			source.Today.High = value
+		}
+		if value < source.Today.Low {
+			source.Today.Low = value
+		}
+	}
+
+	if err := save(); err != nil {
+		log.Printf("degreedays: unable to save %s: %v", filename(), err)
+	}
+}
+
+// save writes the state, the lock must be held
+func save() error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(config.Config.DataDir, 0755); err != nil {
+		return err
+	}
+
+	tmp := filename() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename())
+}
+
+// Report adds the daily and season to date totals for each source to the exporter report, in
+// degrees of the configured temperature unit
+func Report(report map[string]string) {
+	unit := config.Config.Units.TemperatureUnit()
+	delta := func(value float64) string {
+		return fmt.Sprintf("%.2f", Temperature.Delta(value, Temperature.Celsius, unit))
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	for name, source := range state.Sources {
+		prefix := "ecowitt_outdoor"
+		labels := sensors.Labels("ecowitt", name)
+		if name != "outdoor" {
+			prefix = "ecowitt_th_sensor"
+			labels = sensors.Labels("ecowitt", name, "channel", strings.TrimPrefix(name, "th"))
+		}
+
+		daily := source.Today.totals()
+		season := Totals{}
+		season.add(source.Season)
+		season.add(daily)
+
+		report[prefix+"_heating_degree_days_daily"+labels] = delta(daily.Heating)
+		report[prefix+"_heating_degree_days_season"+labels] = delta(season.Heating)
+		report[prefix+"_cooling_degree_days_daily"+labels] = delta(daily.Cooling)
+		report[prefix+"_cooling_degree_days_season"+labels] = delta(season.Cooling)
+		for _, c := range crops {
+			report[fmt.Sprintf("%s_growing_degree_days_%s_daily%s", prefix, c.name, labels)] = delta(daily.Growing[c.name])
+			report[fmt.Sprintf("%s_growing_degree_days_%s_season%s", prefix, c.name, labels)] = delta(season.Growing[c.name])
+		}
+	}
+}
@@ -0,0 +1,231 @@
+'use strict';
+
+// The dashboard loads the current conditions, then keeps them up to date from the stream. The
+// charts and sensor list are reloaded every few minutes.
+
+const stations = {};
+
+const compass = ['N', 'NNE', 'NE', 'ENE', 'E', 'ESE', 'SE', 'SSE', 'S', 'SSW', 'SW', 'WSW', 'W', 'WNW', 'NW', 'NNW'];
+
+function format(v) {
+    if (!v) {
+        return '–';
+    }
+    const digits = Math.abs(v.value) >= 100 || Number.isInteger(v.value) ? 0 : 1;
+    const value = v.value.toFixed(digits);
+    if (!v.unit) {
+        return value;
+    }
+    return v.unit === '%' || v.unit.startsWith('°') ? value + v.unit : value + ' ' + v.unit;
+}
+
+function ago(time) {
+    if (!time) {
+        return 'never';
+    }
+    const seconds = Math.round((Date.now() - new Date(time)) / 1000);
+    if (seconds < 90) {
+        return seconds + 's ago';
+    }
+    if (seconds < 90 * 60) {
+        return Math.round(seconds / 60) + 'm ago';
+    }
+    if (seconds < 36 * 3600) {
+        return Math.round(seconds / 3600) + 'h ago';
+    }
+    return Math.round(seconds / 86400) + 'd ago';
+}
+
+function element(tag, attributes, text) {
+    const e = document.createElementNS(tag === 'svg' || attributes.svg ? 'http://www.w3.org/2000/svg' : 'http://www.w3.org/1999/xhtml', tag);
+    for (const [name, value] of Object.entries(attributes)) {
+        if (name !== 'svg') {
+            e.setAttribute(name, value);
+        }
+    }
+    if (text !== undefined) {
+        e.textContent = text;
+    }
+    return e;
+}
+
+// sections returns the sections of every station by name, the ecowitt station first
+function sections() {
+    const s = {};
+    for (const name of ['airgradient', 'ecowitt']) {
+        const station = stations[name];
+        if (!station) {
+            continue;
+        }
+        for (const section of ['gateway', 'outdoor', 'lightning', 'air_quality']) {
+            if (station[section]) {
+                s[section] = station[section];
+            }
+        }
+        if (station.channels) {
+            s.channels = station.channels;
+        }
+    }
+    return s;
+}
+
+function render() {
+    const s = sections();
+
+    for (const e of document.querySelectorAll('[data-value]')) {
+        const [section, field] = e.dataset.value.split('.');
+        e.textContent = format(s[section] && s[section][field]);
+    }
+
+    for (const [id, section] of [['outdoor', 'outdoor'], ['indoor', 'gateway'], ['pressure', 'gateway'], ['wind', 'outdoor'], ['rain', 'outdoor'], ['sun', 'outdoor'], ['lightning', 'lightning'], ['air', 'air_quality']]) {
+        const tile = document.getElementById(id);
+        tile.classList.toggle('stale', !!(s[section] && s[section].stale));
+        if (id === 'lightning' || id === 'air') {
+            tile.classList.toggle('hidden', !s[section]);
+        }
+    }
+
+    const direction = s.outdoor && s.outdoor.wind_direction;
+    if (direction) {
+        document.getElementById('needle').setAttribute('transform', 'rotate(' + (direction.value + 180) % 360 + ')');
+        document.getElementById('direction').textContent = compass[Math.round(direction.value / 22.5) % 16] + ' (' + Math.round(direction.value) + '°)';
+    }
+
+    const channels = document.querySelector('#channels tbody');
+    channels.replaceChildren();
+    for (const c of s.channels || []) {
+        const row = element('tr', c.stale ? { class: 'stale' } : {});
+        row.append(element('td', {}, c.name), element('td', {}, format(c.temperature)), element('td', {}, format(c.humidity)), element('td', {}, ago(c.received)));
+        channels.append(row);
+    }
+    document.getElementById('channels-section').classList.toggle('hidden', !s.channels);
+
+    const received = Object.values(stations).map(station => station.received).filter(t => t).sort();
+    document.getElementById('updated').textContent = received.length ? 'Updated ' + ago(received[received.length - 1]) : '';
+}
+
+// chart draws series as lines over the last 24 hours
+function chart(id, series) {
+    const svg = document.getElementById(id);
+    const width = svg.clientWidth || 340;
+    const height = svg.clientHeight || 160;
+    const left = 40, right = 8, top = 16, bottom = 18;
+    svg.setAttribute('viewBox', '0 0 ' + width + ' ' + height);
+    svg.replaceChildren();
+
+    const end = Date.now(), start = end - 24 * 3600 * 1000;
+    const points = series.flatMap(s => s.points);
+    if (points.length === 0) {
+        svg.append(element('text', { svg: true, x: width / 2, y: height / 2, class: 'label', 'text-anchor': 'middle' }, 'No data yet'));
+        return;
+    }
+
+    let min = Math.min(...points.map(p => p.value));
+    let max = Math.max(...points.map(p => p.value));
+    if (max - min < 1) {
+        min -= 0.5;
+        max += 0.5;
+    }
+    const x = t => left + (new Date(t) - start) / (end - start) * (width - left - right);
+    const y = v => top + (max - v) / (max - min) * (height - top - bottom);
+
+    for (const v of [min, (min + max) / 2, max]) {
+        svg.append(element('line', { svg: true, x1: left, x2: width - right, y1: y(v), y2: y(v), class: 'grid' }));
+        svg.append(element('text', { svg: true, x: left - 4, y: y(v) + 3, class: 'label', 'text-anchor': 'end' }, v.toFixed(max - min < 10 ? 1 : 0)));
+    }
+    for (let hours = 24; hours > 0; hours -= 6) {
+        const t = new Date(end - hours * 3600 * 1000);
+        t.setMinutes(0, 0, 0);
+        svg.append(element('text', { svg: true, x: x(t), y: height - 4, class: 'label', 'text-anchor': 'middle' }, String(t.getHours()).padStart(2, '0') + ':00'));
+    }
+
+    let legend = left;
+    series.forEach((s, i) => {
+        const d = s.points.map((p, j) => (j ? 'L' : 'M') + x(p.time).toFixed(1) + ' ' + y(p.value).toFixed(1)).join(' ');
+        svg.append(element('path', { svg: true, d: d, class: 'series-' + i }));
+        const label = element('text', { svg: true, x: legend, y: 10, class: 'label legend series-' + i }, s.label + (s.unit ? ' (' + s.unit + ')' : ''));
+        svg.append(label);
+        legend += 110;
+    });
+}
+
+async function loadTimeline() {
+    const response = await fetch('/api/v1/timeline?field=temperature,humidity,pressure_relative,wind_speed,wind_gust,rain_daily,co2,pm2_5');
+    if (!response.ok) {
+        return;
+    }
+    const timeline = await response.json();
+    const pick = (station, sensor, field, label) => timeline
+        .filter(s => s.station === station && (sensor === null || s.sensor === sensor) && s.field === field)
+        .map(s => Object.assign(s, { label: label }));
+
+    chart('chart-temperature', [...pick('ecowitt', 'outdoor', 'temperature', 'Outdoor'), ...pick('ecowitt', 'gateway', 'temperature', 'Indoor')]);
+    chart('chart-humidity', [...pick('ecowitt', 'outdoor', 'humidity', 'Outdoor'), ...pick('ecowitt', 'gateway', 'humidity', 'Indoor')]);
+    chart('chart-pressure', pick('ecowitt', 'gateway', 'pressure_relative', 'Relative'));
+    chart('chart-wind', [...pick('ecowitt', 'outdoor', 'wind_speed', 'Speed'), ...pick('ecowitt', 'outdoor', 'wind_gust', 'Gust')]);
+    chart('chart-rain', pick('ecowitt', 'outdoor', 'rain_daily', 'Daily'));
+    chart('chart-air', pick('airgradient', null, 'pm2_5', 'PM2.5'));
+}
+
+async function loadSensors() {
+    const response = await fetch('/api/v1/sensors');
+    if (!response.ok) {
+        return;
+    }
+    const tbody = document.querySelector('#sensors tbody');
+    tbody.replaceChildren();
+    for (const s of await response.json()) {
+        if (!s.active) {
+            continue;
+        }
+        const row = element('tr', s.up ? {} : { class: 'stale' });
+        const battery = element('td', {});
+        if (s.battery) {
+            battery.append(element('span', { class: 'battery ' + s.battery }, s.battery + (s.voltage ? ' ' + s.voltage.toFixed(2) + ' V' : '')));
+        }
+        row.append(element('td', {}, s.name), element('td', {}, s.station), element('td', {}, s.sensor), element('td', {}, s.zone || ''),
+            element('td', {}, s.up ? 'yes' : 'no'), battery, element('td', {}, ago(s.last_seen)));
+        tbody.append(row);
+    }
+}
+
+async function loadCurrent() {
+    const response = await fetch('/api/v1/current');
+    if (!response.ok) {
+        return;
+    }
+    for (const station of (await response.json()).stations) {
+        stations[station.station] = station;
+    }
+    render();
+}
+
+function connect() {
+    const status = document.getElementById('status');
+    const events = new EventSource('/api/v1/stream');
+    events.onopen = () => {
+        status.textContent = 'Live';
+        status.className = 'status live';
+    };
+    events.onerror = () => {
+        status.textContent = 'Reconnecting…';
+        status.className = 'status offline';
+    };
+    events.addEventListener('update', e => {
+        const update = JSON.parse(e.data);
+        stations[update.station] = update;
+        render();
+    });
+}
+
+function safely(f) {
+    return () => f().catch(err => console.error(err));
+}
+
+safely(loadCurrent)().then(connect);
+safely(loadTimeline)();
+safely(loadSensors)();
+setInterval(safely(loadTimeline), 5 * 60 * 1000);
+setInterval(safely(loadSensors), 60 * 1000);
+setInterval(render, 30 * 1000);
+window.addEventListener('resize', safely(loadTimeline));
@@ -0,0 +1,127 @@
+package ecowitt
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+/*
+ * Ecowitt sensors report their battery in different ways depending on the model:
+ *   wh65batt, batt1-8      0 = OK, 1 = low
+ *   wh57batt, leakbatt1-4  level 0-5, 6 when powered by DC
+ *   soilbatt1-8            voltage of the single AA cell
+ * These are normalised to a common state, with the voltage where the sensor reports it.
+ */
+
+type BatteryState int8
+
+const (
+	BatteryUnknown BatteryState = iota
+	BatteryOK
+	BatteryLow
+	BatteryCritical
+)
+
+func (s BatteryState) String() string {
+	switch s {
+	case BatteryOK:
+		return "ok"
+	case BatteryLow:
+		return "low"
+	case BatteryCritical:
+		return "critical"
+	}
+	return "unknown"
+}
+
+// Battery is the normalised battery health of a sensor
+type Battery struct {
+	State   BatteryState
+	Voltage float64 // 0 when the sensor does not report a voltage
+}
+
+// flagBattery normalises a low battery flag
+func flagBattery(value float64) Battery {
+	if value == 0 {
+		return Battery{State: BatteryOK}
+	}
+	return Battery{State: BatteryLow}
+}
+
+// levelBattery normalises a 0-5 battery level
+func levelBattery(value float64) Battery {
+	switch {
+	case value >= 2:
+		return Battery{State: BatteryOK}
+	case value == 1:
+		return Battery{State: BatteryLow}
+	}
+	return Battery{State: BatteryCritical}
+}
+
+// voltageBattery normalises the voltage of a single 1.5V cell
+func voltageBattery(value float64) Battery {
+	value = math.Round(value*100) / 100
+	switch {
+	case value > 1.2:
+		return Battery{State: BatteryOK, Voltage: value}
+	case value > 1.1:
+		return Battery{State: BatteryLow, Voltage: value}
+	}
+	return Battery{State: BatteryCritical, Voltage: value}
+}
+
+// BatteryHealth returns the battery health of the WS65 outdoor sensor array
+func (s OutdoorSensorArray) BatteryHealth() Battery {
+	return flagBattery(float64(s.Battery))
+}
+
+// BatteryHealth returns the battery health of a WH31 temperature/humidity sensor
+func (s TemperatureHumiditySensor) BatteryHealth() Battery {
+	return flagBattery(s.Battery)
+}
+
+// BatteryHealth returns the battery health of a WH51 soil moisture sensor
+func (s SoilSensor) BatteryHealth() Battery {
+	return voltageBattery(s.Battery)
+}
+
+// BatteryHealth returns the battery health of a WH57 lightning sensor
+func (s LightningSensor) BatteryHealth() Battery {
+	return levelBattery(float64(s.Battery))
+}
+
+// BatteryHealth returns the battery health of a WH55 leak detector
+func (s LeakSensor) BatteryHealth() Battery {
+	return levelBattery(s.Battery)
+}
+
+// Batteries returns the battery health of each sensor in the last report, by sensor name
+func (ws WeatherStation) Batteries() map[string]Battery {
+	batteries := map[string]Battery{}
+	if _, ok := ws.LastSeen["outdoor"]; ok {
+		batteries["outdoor"] = ws.Outdoor.BatteryHealth()
+	}
+	if _, ok := ws.LastSeen["lightning"]; ok {
+		batteries["lightning"] = ws.Lightning.BatteryHealth()
+	}
+	for _, sensor := range ws.TemperatureHumidity {
+		batteries[fmt.Sprintf("th%d", sensor.ID)] = sensor.BatteryHealth()
+	}
+	for _, sensor := range ws.SoilMoisture {
+		batteries[fmt.Sprintf("soil%d", sensor.ID)] = sensor.BatteryHealth()
+	}
+	for _, sensor := range ws.Leak {
+		batteries[fmt.Sprintf("leak%d", sensor.ID)] = sensor.BatteryHealth()
+	}
+	return batteries
+}
+
+// seen records that a sensor was included in a report
+func (ws *WeatherStation) seen(sensor string, t time.Time) {
+	if ws.LastSeen == nil {
+		ws.LastSeen = map[string]time.Time{}
+	}
+	ws.LastSeen[sensor] = t
+}
@@ -1,16 +1,27 @@
 package ecowitt
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
+	"time"
 
+	"neverending.dev/weather/calibration"
+	"neverending.dev/weather/config"
 	"neverending.dev/weather/measurement/Humidity"
 	"neverending.dev/weather/measurement/Moisture"
 	"neverending.dev/weather/measurement/Pressure"
 	"neverending.dev/weather/measurement/Rainfall"
 	"neverending.dev/weather/measurement/Temperature"
 	"neverending.dev/weather/measurement/Velocity"
+	"neverending.dev/weather/quality"
+	"neverending.dev/weather/state"
 )
 
 /*
@@ -125,11 +136,21 @@ type TemperatureHumiditySensor struct {
 	Battery     float64
 }
 
-// SoilSensor holds the data for an ecowitt WH51 soil moisture sensor
+// SoilSensor holds the data for an ecowitt WH51 soil moisture sensor. Moisture is calibrated from
+// the raw AD reading when the channel has a calibration, otherwise it is the sensor's own reading.
 type SoilSensor struct {
-	ID       int
-	Moisture Moisture.Moisture
-	Battery  float64
+	ID          int
+	Moisture    Moisture.Moisture
+	RawMoisture Moisture.Moisture // soilmoisture, the sensor's factory calibration
+	AD          int64             // soilad
+	Battery     float64
+}
+
+// LeakSensor holds the data for an Ecowitt WH55 water leak detector
+type LeakSensor struct {
+	ID      int
+	Leak    bool // leak_ch, water detected
+	Battery float64
 }
 
 type WeatherStation struct {
@@ -138,7 +159,9 @@ type WeatherStation struct {
 	Outdoor             OutdoorSensorArray
 	TemperatureHumidity []TemperatureHumiditySensor
 	SoilMoisture        []SoilSensor
+	Leak                []LeakSensor
 	Lightning           LightningSensor
+	LastSeen            map[string]time.Time // time each sensor was last included in a report
 }
 
 // LigthningSensor holds the data for an Ecowitt WH57 lightning sensor
@@ -188,9 +211,59 @@ var WS = WeatherStation{
 		Time:     0,
 		Battery:  0,
 	},
+	LastSeen: map[string]time.Time{},
 }
 
+// listeners are called each time a report has been processed
+var listeners []func()
+
+// OnReport registers f to be called after each report from the gateway has been processed
+func OnReport(f func()) {
+	listeners = append(listeners, f)
+}
+
+// postListeners are called with the body of each report as the gateway posted it
+var postListeners []func(body []byte)
+
+// OnPost registers f to be called with the body of each report posted by the gateway, before it
+// is ingested
+func OnPost(f func(body []byte)) {
+	postListeners = append(postListeners, f)
+}
+
+// ObservationTime returns the time the gateway took the current readings. The gateway reports
+// dateutc in UTC, if it is missing or unparseable the current time is used instead.
+func (ws WeatherStation) ObservationTime() time.Time {
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", ws.Gateway.DateUTC, time.UTC); err == nil {
+		return t
+	}
+	return time.Now()
+}
+
+// accept runs a reading from the gateway through the quality checks, field is the form field the
+// reading was posted in
+func accept(field string, sensor string, quantity string, value float64) bool {
+	return quality.Accept("ecowitt", sensor, field, quantity, value)
+}
+
+// maxReport is the largest report body read, the gateway's reports are a few kilobytes
+const maxReport = 64 << 10
+
 func ReportHandler(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxReport))
+	if err != nil {
+		log.Printf("ecowitt: unable to read report: %v", err)
+		// http.MaxBytesError is newer than the Go the image builds with, a body cut off at the
+		// limit is the same error
+		status := http.StatusBadRequest
+		if len(body) >= maxReport {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+
 	if err := req.ParseForm(); err != nil {
 		fmt.Printf("ParseForm() err: %v", err)
 		return
@@ -198,133 +271,291 @@ func ReportHandler(w http.ResponseWriter, req *http.Request) {
 
 	fmt.Printf("%q\n", req.PostForm)
 
-	if req.PostForm.Get("stationtype") != "" {
-		//WS := new(WeatherStation)
+	// only reports from a gateway are relayed, not requests without a report
+	if req.Method != http.MethodPost || req.PostForm.Get("stationtype") == "" {
+		return
+	}
+	for _, f := range postListeners {
+		f(body)
+	}
+	Ingest(req.PostForm, true)
+}
+
+// outdoorFields are posted by the outdoor sensor array
+var outdoorFields = []string{
+	"tempf", "humidity", "windspeedmph", "winddir", "windgustmph", "solarradiation", "uv", "rainratein",
+	"eventrainin", "hourlyrainin", "dailyrainin", "weeklyrainin", "monthlyrainin", "yearlyrainin", "totalrainin", "wh65batt",
+}
 
-		// Indicate the structure is being updated
-		WS.Status = NotReady
+// partialSensors are the channels updated by partial reports, kept when missing from the gateway's
+// reports
+var partialSensors = map[string]bool{}
 
-		WS.Gateway.PASSKEY = req.PostForm.Get("PASSKEY")
-		WS.Gateway.StationType = req.PostForm.Get("stationtype")
-		WS.Gateway.Model = req.PostForm.Get("model")
-		WS.Gateway.Frequency = req.PostForm.Get("freq")
-		WS.Gateway.DateUTC = req.PostForm.Get("dateutc")
+// Ingest updates the station from the fields of a report. The gateway posts every sensor in each
+// report, so channels missing from a complete report are removed. A partial report, such as a
+// single sensor received over MQTT, only updates the sensors it includes.
+func Ingest(form url.Values, complete bool) {
+	state.Ingest(func() { ingest(form, complete) }, listeners)
+}
 
-		if f, err := strconv.ParseFloat(req.PostForm.Get("tempinf"), 32); err == nil {
-			WS.Gateway.Temperature = Temperature.New(f, Temperature.Farenheit)
-		}
-		if h, err := strconv.ParseInt(req.PostForm.Get("humidityin"), 10, 64); err == nil {
-			WS.Gateway.Humidity = Humidity.New(h)
-		}
-		if b, err := strconv.ParseFloat(req.PostForm.Get("baromrelin"), 32); err == nil {
-			WS.Gateway.PressureRelative = Pressure.New(b, Pressure.InchOfMercury)
-		}
-		if b, err := strconv.ParseFloat(req.PostForm.Get("baromabsin"), 32); err == nil {
-			WS.Gateway.PressureAbsolute = Pressure.New(b, Pressure.InchOfMercury)
+// Snapshot returns a copy of the station, safe to read while reports are being received
+func Snapshot() WeatherStation {
+	ws := WeatherStation{}
+	state.Read(func() {
+		ws = WS
+		ws.TemperatureHumidity = append([]TemperatureHumiditySensor(nil), WS.TemperatureHumidity...)
+		ws.SoilMoisture = append([]SoilSensor(nil), WS.SoilMoisture...)
+		ws.Leak = append([]LeakSensor(nil), WS.Leak...)
+		ws.LastSeen = map[string]time.Time{}
+		for sensor, t := range WS.LastSeen {
+			ws.LastSeen[sensor] = t
 		}
+	})
+	return ws
+}
 
-		// Outdoor Sensor Array
-		if v, err := strconv.ParseFloat(req.PostForm.Get("tempf"), 32); err == nil {
-			WS.Outdoor.Temperature = Temperature.New(v, Temperature.Farenheit)
-		}
-		if v, err := strconv.ParseInt(req.PostForm.Get("humidity"), 10, 64); err == nil {
-			WS.Outdoor.Humidity = Humidity.New(v)
-		}
-		if v, err := strconv.ParseFloat(req.PostForm.Get("windspeedmph"), 32); err == nil {
-			WS.Outdoor.WindSpeed = Velocity.New(v, Velocity.MilesPerHour)
-		}
-		if v, err := strconv.ParseInt(req.PostForm.Get("winddir"), 10, 64); err == nil {
-			WS.Outdoor.WindDirection = v
+func ingest(form url.Values, complete bool) {
+	// Indicate the structure is being updated
+	WS.Status = NotReady
+
+	received := time.Now()
+	if complete {
+		WS.Gateway.PASSKEY = form.Get("PASSKEY")
+		WS.Gateway.StationType = form.Get("stationtype")
+		WS.Gateway.Model = form.Get("model")
+		WS.Gateway.Frequency = form.Get("freq")
+		WS.Gateway.DateUTC = form.Get("dateutc")
+		WS.seen("gateway", received)
+	} else {
+		WS.Gateway.DateUTC = received.UTC().Format("2006-01-02 15:04:05")
+		if form.Get("tempinf") != "" || form.Get("humidityin") != "" || form.Get("baromrelin") != "" || form.Get("baromabsin") != "" {
+			WS.seen("gateway", received)
 		}
-		if v, err := strconv.ParseFloat(req.PostForm.Get("windgustmph"), 32); err == nil {
-			WS.Outdoor.WindGust = Velocity.New(v, Velocity.MilesPerHour)
+	}
+
+	for _, field := range outdoorFields {
+		if form.Get(field) != "" {
+			WS.seen("outdoor", received)
+			break
 		}
-		if v, err := strconv.ParseFloat(req.PostForm.Get("solarradiation"), 32); err == nil {
-			WS.Outdoor.SolarRadiation = v
+	}
+	if form.Get("wh57batt") != "" {
+		WS.seen("lightning", received)
+	}
+
+	if f, err := strconv.ParseFloat(form.Get("tempinf"), 32); err == nil {
+		if r := calibration.Temperature("ecowitt", "gateway", Temperature.New(f, Temperature.Farenheit)); accept("tempinf", "gateway", "temperature", r.Get(Temperature.Celsius)) {
+			WS.Gateway.Temperature = r
 		}
-		if v, err := strconv.ParseInt(req.PostForm.Get("uv"), 10, 64); err == nil {
-			WS.Outdoor.UV = v
+	}
+	if h, err := strconv.ParseInt(form.Get("humidityin"), 10, 64); err == nil {
+		if r := calibration.Humidity("ecowitt", "gateway", Humidity.New(h)); accept("humidityin", "gateway", "humidity", float64(r.Get())) {
+			WS.Gateway.Humidity = r
 		}
-		if v, err := strconv.ParseFloat(req.PostForm.Get("rainratein"), 32); err == nil {
-			WS.Outdoor.RainRate = Rainfall.New(v, Rainfall.Inch)
+	}
+	if b, err := strconv.ParseFloat(form.Get("baromrelin"), 32); err == nil {
+		if r := calibration.Pressure("ecowitt", "gateway", Pressure.New(b, Pressure.InchOfMercury)); accept("baromrelin", "gateway", "pressure", r.Get(Pressure.Hectopascal)) {
+			WS.Gateway.PressureRelative = r
 		}
-		if v, err := strconv.ParseFloat(req.PostForm.Get("eventrainin"), 32); err == nil {
-			WS.Outdoor.RainEvent = Rainfall.New(v, Rainfall.Inch)
+	}
+	if b, err := strconv.ParseFloat(form.Get("baromabsin"), 32); err == nil {
+		if r := calibration.Pressure("ecowitt", "gateway", Pressure.New(b, Pressure.InchOfMercury)); accept("baromabsin", "gateway", "pressure", r.Get(Pressure.Hectopascal)) {
+			WS.Gateway.PressureAbsolute = r
 		}
-		if v, err := strconv.ParseFloat(req.PostForm.Get("hourlyrainin"), 32); err == nil {
-			WS.Outdoor.RainHourly = Rainfall.New(v, Rainfall.Inch)
+	}
+
+	// Outdoor Sensor Array
+	if v, err := strconv.ParseFloat(form.Get("tempf"), 32); err == nil {
+		if r := calibration.Temperature("ecowitt", "outdoor", Temperature.New(v, Temperature.Farenheit)); accept("tempf", "outdoor", "temperature", r.Get(Temperature.Celsius)) {
+			WS.Outdoor.Temperature = r
 		}
-		if v, err := strconv.ParseFloat(req.PostForm.Get("dailyrainin"), 32); err == nil {
-			WS.Outdoor.RainDaily = Rainfall.New(v, Rainfall.Inch)
+	}
+	if v, err := strconv.ParseInt(form.Get("humidity"), 10, 64); err == nil {
+		if r := calibration.Humidity("ecowitt", "outdoor", Humidity.New(v)); accept("humidity", "outdoor", "humidity", float64(r.Get())) {
+			WS.Outdoor.Humidity = r
 		}
-		if v, err := strconv.ParseFloat(req.PostForm.Get("weeklyrainin"), 32); err == nil {
-			WS.Outdoor.RainWeekly = Rainfall.New(v, Rainfall.Inch)
+	}
+	if v, err := strconv.ParseFloat(form.Get("windspeedmph"), 32); err == nil {
+		if r := calibration.Velocity("ecowitt", "outdoor", Velocity.New(v, Velocity.MilesPerHour)); accept("windspeedmph", "outdoor", "wind_speed", r.Get(Velocity.KilometresPerHour)) {
+			WS.Outdoor.WindSpeed = r
 		}
-		if v, err := strconv.ParseFloat(req.PostForm.Get("monthlyrainin"), 32); err == nil {
-			WS.Outdoor.RainMonthly = Rainfall.New(v, Rainfall.Inch)
+	}
+	if v, err := strconv.ParseInt(form.Get("winddir"), 10, 64); err == nil {
+		WS.Outdoor.WindDirection = v
+	}
+	if v, err := strconv.ParseFloat(form.Get("windgustmph"), 32); err == nil {
+		if r := calibration.Velocity("ecowitt", "outdoor", Velocity.New(v, Velocity.MilesPerHour)); accept("windgustmph", "outdoor", "wind_gust", r.Get(Velocity.KilometresPerHour)) {
+			WS.Outdoor.WindGust = r
 		}
-		if v, err := strconv.ParseFloat(req.PostForm.Get("yearlyrainin"), 32); err == nil {
-			WS.Outdoor.RainYearly = Rainfall.New(v, Rainfall.Inch)
+	}
+	if v, err := strconv.ParseFloat(form.Get("solarradiation"), 32); err == nil {
+		if r := calibration.Value("ecowitt", "outdoor", "solar", v); accept("solarradiation", "outdoor", "solar", r) {
+			WS.Outdoor.SolarRadiation = r
 		}
-		if v, err := strconv.ParseFloat(req.PostForm.Get("totalrainin"), 32); err == nil {
-			WS.Outdoor.RainTotal = Rainfall.New(v, Rainfall.Inch)
+	}
+	if v, err := strconv.ParseInt(form.Get("uv"), 10, 64); err == nil {
+		if r := math.Round(calibration.Value("ecowitt", "outdoor", "uv", float64(v))); accept("uv", "outdoor", "uv", r) {
+			WS.Outdoor.UV = int64(r)
 		}
-		if v, err := strconv.ParseInt(req.PostForm.Get("wh65batt"), 10, 64); err == nil {
-			WS.Outdoor.Battery = v
+	}
+	if v, err := strconv.ParseFloat(form.Get("rainratein"), 32); err == nil {
+		if r := calibration.Rainfall("ecowitt", "outdoor", Rainfall.New(v, Rainfall.Inch)); accept("rainratein", "outdoor", "rain_rate", r.Get(Rainfall.Millimetre)) {
+			WS.Outdoor.RainRate = r
 		}
+	}
+	if v, err := strconv.ParseFloat(form.Get("eventrainin"), 32); err == nil {
+		WS.Outdoor.RainEvent = calibration.Rainfall("ecowitt", "outdoor", Rainfall.New(v, Rainfall.Inch))
+	}
+	if v, err := strconv.ParseFloat(form.Get("hourlyrainin"), 32); err == nil {
+		WS.Outdoor.RainHourly = calibration.Rainfall("ecowitt", "outdoor", Rainfall.New(v, Rainfall.Inch))
+	}
+	if v, err := strconv.ParseFloat(form.Get("dailyrainin"), 32); err == nil {
+		WS.Outdoor.RainDaily = calibration.Rainfall("ecowitt", "outdoor", Rainfall.New(v, Rainfall.Inch))
+	}
+	if v, err := strconv.ParseFloat(form.Get("weeklyrainin"), 32); err == nil {
+		WS.Outdoor.RainWeekly = calibration.Rainfall("ecowitt", "outdoor", Rainfall.New(v, Rainfall.Inch))
+	}
+	if v, err := strconv.ParseFloat(form.Get("monthlyrainin"), 32); err == nil {
+		WS.Outdoor.RainMonthly = calibration.Rainfall("ecowitt", "outdoor", Rainfall.New(v, Rainfall.Inch))
+	}
+	if v, err := strconv.ParseFloat(form.Get("yearlyrainin"), 32); err == nil {
+		WS.Outdoor.RainYearly = calibration.Rainfall("ecowitt", "outdoor", Rainfall.New(v, Rainfall.Inch))
+	}
+	if v, err := strconv.ParseFloat(form.Get("totalrainin"), 32); err == nil {
+		WS.Outdoor.RainTotal = calibration.Rainfall("ecowitt", "outdoor", Rainfall.New(v, Rainfall.Inch))
+	}
+	if v, err := strconv.ParseInt(form.Get("wh65batt"), 10, 64); err == nil {
+		WS.Outdoor.Battery = v
+	}
 
-		// Multi-channel Temperature/Humidity Sensors
-		WS.TemperatureHumidity = nil
-		for i := 1; i < 8; i++ {
-			if req.PostForm.Get(fmt.Sprintf("temp%df", i)) != "" {
-				ts := new(TemperatureHumiditySensor)
-				ts.ID = i
-				if f, err := strconv.ParseFloat(req.PostForm.Get(fmt.Sprintf("temp%df", i)), 32); err == nil {
-					ts.Temperature = Temperature.New(f, Temperature.Farenheit)
+	// Multi-channel Temperature/Humidity Sensors. Readings that fail the quality checks keep
+	// the previous reading from the channel.
+	previousTH := WS.TemperatureHumidity
+	WS.TemperatureHumidity = nil
+	for i := 1; i <= 8; i++ {
+		if form.Get(fmt.Sprintf("temp%df", i)) != "" {
+			ts := new(TemperatureHumiditySensor)
+			for _, previous := range previousTH {
+				if previous.ID == i {
+					*ts = previous
 				}
-				if h, err := strconv.ParseInt(req.PostForm.Get(fmt.Sprintf("humidity%d", i)), 10, 64); err == nil {
-					ts.Humidity = Humidity.New(h)
+			}
+			ts.ID = i
+			sensor := fmt.Sprintf("th%d", i)
+			WS.seen(sensor, received)
+			if !complete {
+				partialSensors[sensor] = true
+			}
+			field := fmt.Sprintf("temp%df", i)
+			if f, err := strconv.ParseFloat(form.Get(field), 32); err == nil {
+				if r := calibration.Temperature("ecowitt", sensor, Temperature.New(f, Temperature.Farenheit)); accept(field, sensor, "temperature", r.Get(Temperature.Celsius)) {
+					ts.Temperature = r
 				}
-				if b, err := strconv.ParseFloat(req.PostForm.Get(fmt.Sprintf("batt%d", i)), 32); err == nil {
-					ts.Battery = b
+			}
+			field = fmt.Sprintf("humidity%d", i)
+			if h, err := strconv.ParseInt(form.Get(field), 10, 64); err == nil {
+				if r := calibration.Humidity("ecowitt", sensor, Humidity.New(h)); accept(field, sensor, "humidity", float64(r.Get())) {
+					ts.Humidity = r
 				}
-				WS.TemperatureHumidity = append(WS.TemperatureHumidity, *ts)
 			}
+			if b, err := strconv.ParseFloat(form.Get(fmt.Sprintf("batt%d", i)), 32); err == nil {
+				ts.Battery = b
+			}
+			WS.TemperatureHumidity = append(WS.TemperatureHumidity, *ts)
 		}
+	}
+	// Keep the channels missing from this report that are fed by partial reports
+	for _, previous := range previousTH {
+		if form.Get(fmt.Sprintf("temp%df", previous.ID)) == "" && (!complete || partialSensors[fmt.Sprintf("th%d", previous.ID)]) {
+			WS.TemperatureHumidity = append(WS.TemperatureHumidity, previous)
+		}
+	}
+	sort.Slice(WS.TemperatureHumidity, func(i, j int) bool { return WS.TemperatureHumidity[i].ID < WS.TemperatureHumidity[j].ID })
 
-		// Multi-channel Soil Moisture Sensors
-		WS.SoilMoisture = nil
-		for i := 1; i < 8; i++ {
-			if req.PostForm.Get(fmt.Sprintf("soilmoisture%d", i)) != "" {
-				ss := new(SoilSensor)
-				ss.ID = i
-				if f, err := strconv.ParseInt(req.PostForm.Get(fmt.Sprintf("soilmoisture%d", i)), 10, 64); err == nil {
-					ss.Moisture = Moisture.New(f)
+	// Multi-channel Soil Moisture Sensors
+	previousSoil := WS.SoilMoisture
+	WS.SoilMoisture = nil
+	for i := 1; i <= 8; i++ {
+		if form.Get(fmt.Sprintf("soilmoisture%d", i)) != "" {
+			ss := new(SoilSensor)
+			for _, previous := range previousSoil {
+				if previous.ID == i {
+					*ss = previous
 				}
-				if b, err := strconv.ParseFloat(req.PostForm.Get(fmt.Sprintf("soilbatt%d", i)), 32); err == nil {
-					ss.Battery = b
+			}
+			ss.ID = i
+			sensor := fmt.Sprintf("soil%d", i)
+			WS.seen(sensor, received)
+			if !complete {
+				partialSensors[sensor] = true
+			}
+			moisture := ss.Moisture
+			if f, err := strconv.ParseInt(form.Get(fmt.Sprintf("soilmoisture%d", i)), 10, 64); err == nil {
+				ss.RawMoisture = Moisture.New(f)
+				moisture = ss.RawMoisture
+			}
+			if ad, err := strconv.ParseInt(form.Get(fmt.Sprintf("soilad%d", i)), 10, 64); err == nil {
+				ss.AD = ad
+				if curve, ok := config.SoilCurve(i); ok {
+					moisture = curve.Apply(float64(ad))
 				}
-				WS.SoilMoisture = append(WS.SoilMoisture, *ss)
 			}
+			if accept(fmt.Sprintf("soilmoisture%d", i), sensor, "moisture", float64(moisture.Get())) {
+				ss.Moisture = moisture
+			}
+			if b, err := strconv.ParseFloat(form.Get(fmt.Sprintf("soilbatt%d", i)), 32); err == nil {
+				ss.Battery = b
+			}
+			WS.SoilMoisture = append(WS.SoilMoisture, *ss)
 		}
-
-		// WH57 Lightning sensor
-		if v, err := strconv.ParseUint(req.PostForm.Get("lightning"), 10, 64); err == nil {
-			WS.Lightning.Distance = v
+	}
+	// Keep the channels missing from this report that are fed by partial reports
+	for _, previous := range previousSoil {
+		if form.Get(fmt.Sprintf("soilmoisture%d", previous.ID)) == "" && (!complete || partialSensors[fmt.Sprintf("soil%d", previous.ID)]) {
+			WS.SoilMoisture = append(WS.SoilMoisture, previous)
 		}
-		if v, err := strconv.ParseUint(req.PostForm.Get("lightning_num"), 10, 64); err == nil {
-			WS.Lightning.Count = v
+	}
+	sort.Slice(WS.SoilMoisture, func(i, j int) bool { return WS.SoilMoisture[i].ID < WS.SoilMoisture[j].ID })
+
+	// WH55 Leak detectors
+	previousLeak := WS.Leak
+	WS.Leak = nil
+	for i := 1; i <= 4; i++ {
+		leak, err := strconv.ParseInt(form.Get(fmt.Sprintf("leak_ch%d", i)), 10, 64)
+		if err != nil {
+			continue
 		}
-		if v, err := strconv.ParseUint(req.PostForm.Get("lightning_time"), 10, 64); err == nil {
-			WS.Lightning.Time = v
+		WS.seen(fmt.Sprintf("leak%d", i), received)
+		ls := LeakSensor{ID: i, Leak: leak != 0}
+		if b, err := strconv.ParseFloat(form.Get(fmt.Sprintf("leakbatt%d", i)), 32); err == nil {
+			ls.Battery = b
 		}
-		if v, err := strconv.ParseUint(req.PostForm.Get("wh57batt"), 10, 64); err == nil {
-			WS.Lightning.Battery = v
+		WS.Leak = append(WS.Leak, ls)
+	}
+	// Keep the detectors missing from a partial report
+	for _, previous := range previousLeak {
+		if form.Get(fmt.Sprintf("leak_ch%d", previous.ID)) == "" && !complete {
+			WS.Leak = append(WS.Leak, previous)
 		}
+	}
+	sort.Slice(WS.Leak, func(i, j int) bool { return WS.Leak[i].ID < WS.Leak[j].ID })
 
-		// Indicate the structure has finished updating
-		WS.Status = Ready
+	// WH57 Lightning sensor
+	if v, err := strconv.ParseUint(form.Get("lightning"), 10, 64); err == nil {
+		WS.Lightning.Distance = v
+	}
+	if v, err := strconv.ParseUint(form.Get("lightning_num"), 10, 64); err == nil {
+		WS.Lightning.Count = v
+	}
+	if v, err := strconv.ParseUint(form.Get("lightning_time"), 10, 64); err == nil {
+		WS.Lightning.Time = v
 	}
+	if v, err := strconv.ParseUint(form.Get("wh57batt"), 10, 64); err == nil {
+		WS.Lightning.Battery = v
+	}
+
+	// Indicate the structure has finished updating
+	WS.Status = Ready
 }
 
 /*
@@ -0,0 +1,345 @@
+package eto
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/ecowitt"
+	"neverending.dev/weather/history"
+	"neverending.dev/weather/measurement/Pressure"
+	"neverending.dev/weather/measurement/Temperature"
+	"neverending.dev/weather/measurement/Velocity"
+)
+
+/*
+ * Reference evapotranspiration (ETo) using the FAO-56 Penman-Monteith hourly equation (eq. 53).
+ * The hourly rate is calculated from each report and integrated over the time since the previous
+ * report, giving hourly and daily totals in mm.
+ *
+ * Richard G. Allen et al, Crop evapotranspiration - Guidelines for computing crop water
+ * requirements, FAO Irrigation and drainage paper 56, 1998
+ */
+
+const (
+	solarConstant = 0.0820    // MJ m-2 min-1
+	stefanBoltz   = 2.043e-10 // MJ K-4 m-2 hour-1
+	albedo        = 0.23      // grass reference crop
+
+	// maxInterval limits how long a single reading is integrated over, so a gap in reports
+	// does not add hours of evapotranspiration at a single rate
+	maxInterval = 15 * time.Minute
+
+	// days of daily totals kept for the water balance
+	keepDays = 31
+)
+
+// Day is the evapotranspiration and rain for a single day, in mm
+type Day struct {
+	Date string  `json:"date"`
+	ETo  float64 `json:"eto"`
+	Rain float64 `json:"rain"`
+}
+
+// State is persisted across restarts
+type State struct {
+	Last       time.Time `json:"last"`        // time of the previous report
+	Rate       float64   `json:"rate"`        // mm/hour at the last report
+	Hour       time.Time `json:"hour"`        // start of the hour being accumulated
+	HourETo    float64   `json:"hour_eto"`    // mm accumulated in the current hour
+	LastHour   float64   `json:"last_hour"`   // mm in the previous complete hour
+	CloudRatio float64   `json:"cloud_ratio"` // Rs/Rso from the last daytime report, used at night
+	Days       []Day     `json:"days"`        // oldest first, the last entry is today
+}
+
+var state = State{CloudRatio: 0.8}
+
+// lock guards state, updated from reports while the metrics and irrigation read it. The exported
+// readers take it, Update uses the unexported ones.
+var lock sync.Mutex
+
+func filename() string {
+	return filepath.Join(config.Config.DataDir, "eto.json")
+}
+
+// Start loads the persisted totals and begins calculating ETo from ecowitt reports
+func Start() {
+	if data, err := os.ReadFile(filename()); err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			log.Printf("eto: unable to load %s: %v", filename(), err)
+		}
+	}
+
+	ecowitt.OnReport(Update)
+}
+
+// saturationVapourPressure returns e°(T) in kPa for a temperature in °C (eq. 11)
+func saturationVapourPressure(t float64) float64 {
+	return 0.6108 * math.Exp(17.27*t/(t+237.3))
+}
+
+// extraterrestrialRadiation returns Ra in MJ m-2 hour-1 for the hour centred on t (eq. 28)
+func extraterrestrialRadiation(t time.Time, latitude float64, longitude float64) float64 {
+	t = t.UTC()
+	j := float64(t.YearDay())
+	phi := latitude * math.Pi / 180
+
+	dr := 1 + 0.033*math.Cos(2*math.Pi*j/365)
+	delta := 0.409 * math.Sin(2*math.Pi*j/365-1.39)
+
+	// Seasonal correction for solar time (eq. 32, 33)
+	b := 2 * math.Pi * (j - 81) / 364
+	sc := 0.1645*math.Sin(2*b) - 0.1255*math.Cos(b) - 0.025*math.Sin(b)
+
+	// Solar time angle (eq. 31), measured from UTC so the timezone meridian is not needed
+	hour := float64(t.Hour()) + float64(t.Minute())/60 + float64(t.Second())/3600
+	omega := math.Pi / 12 * (hour + longitude/15 + sc - 12)
+	omega1 := omega - math.Pi/24
+	omega2 := omega + math.Pi/24
+
+	// Limit to sunrise and sunset (eq. 25)
+	omegaS := math.Acos(math.Max(-1, math.Min(1, -math.Tan(phi)*math.Tan(delta))))
+	omega1 = math.Max(-omegaS, math.Min(omegaS, omega1))
+	omega2 = math.Max(-omegaS, math.Min(omegaS, omega2))
+	if omega1 >= omega2 {
+		return 0
+	}
+
+	return 12 * 60 / math.Pi * solarConstant * dr *
+		((omega2-omega1)*math.Sin(phi)*math.Sin(delta) + math.Cos(phi)*math.Cos(delta)*(math.Sin(omega2)-math.Sin(omega1)))
+}
+
+// clearSkyRatio returns Rs/Rso, the relative shortwave radiation used for net longwave radiation
+// (eq. 37, 39). At night Rso is zero so the ratio from the last daytime report is kept. The lock
+// must be held.
+func clearSkyRatio(t time.Time, solar float64) float64 {
+	station := config.Config.Station
+	rs := solar * 0.0036
+	rso := (0.75 + 2e-5*station.Elevation) * extraterrestrialRadiation(t, station.Latitude, station.Longitude)
+	if rso > 0.05 {
+		state.CloudRatio = math.Max(0.25, math.Min(1.0, rs/rso))
+	}
+	return state.CloudRatio
+}
+
+// CloudRatio returns Rs/Rso from the last daytime report, 1.0 for a clear sky
+func CloudRatio() float64 {
+	lock.Lock()
+	defer lock.Unlock()
+	return state.CloudRatio
+}
+
+// Hourly returns the reference evapotranspiration rate in mm/hour
+//
+//	temp       air temperature in °C
+//	humidity   relative humidity in %
+//	wind       wind speed at 2m in m/s
+//	solar      solar radiation in W/m2
+//	pressure   atmospheric pressure in kPa
+//	ratio      relative shortwave radiation Rs/Rso, 0.25 to 1.0
+func Hourly(temp float64, humidity float64, wind float64, solar float64, pressure float64, ratio float64) float64 {
+	es := saturationVapourPressure(temp)
+	ea := es * humidity / 100
+	slope := 4098 * es / math.Pow(temp+237.3, 2)
+	gamma := 0.000665 * pressure
+
+	// Net radiation (eq. 38, 39, 40)
+	rns := (1 - albedo) * solar * 0.0036
+	rnl := stefanBoltz * math.Pow(temp+273.16, 4) * (0.34 - 0.14*math.Sqrt(ea)) * (1.35*ratio - 0.35)
+	rn := rns - rnl
+
+	// Soil heat flux (eq. 45, 46)
+	g := 0.5 * rn
+	if rn > 0 {
+		g = 0.1 * rn
+	}
+
+	eto := (0.408*slope*(rn-g) + gamma*(37/(temp+273))*wind*(es-ea)) / (slope + gamma*(1+0.34*wind))
+	return math.Max(0, eto)
+}
+
+// windAt2m converts a wind speed measured at the anemometer height to 2m (eq. 47)
+func windAt2m(speed float64) float64 {
+	z := config.Config.Station.AnemometerHeight
+	if z <= 0 || z == 2 {
+		return speed
+	}
+	return speed * 4.87 / math.Log(67.8*z-5.42)
+}
+
+// pressure returns the measured absolute pressure in kPa, falling back to the pressure
+// estimated from the station elevation (eq. 7)
+func pressure() float64 {
+	if p := ecowitt.WS.Gateway.PressureAbsolute.Get(Pressure.Kilopascal); p > 0 {
+		return p
+	}
+	return 101.3 * math.Pow((293-0.0065*config.Config.Station.Elevation)/293, 5.26)
+}
+
+// Update integrates the current rate over the time since the previous report
+func Update() {
+	t := ecowitt.WS.ObservationTime()
+	local := t.In(config.Location())
+
+	temperature := ecowitt.WS.Outdoor.Temperature.Get(Temperature.Celsius)
+	humidity := float64(ecowitt.WS.Outdoor.Humidity.Get())
+	wind := windAt2m(ecowitt.WS.Outdoor.WindSpeed.Get(Velocity.MetresPerSecond))
+	solar := ecowitt.WS.Outdoor.SolarRadiation
+
+	// a reading the outdoor sensor array has not reported would make the rate NaN from then on.
+	// Humidity has no NaN, it is 0 until the array has reported.
+	if _, ok := ecowitt.WS.LastSeen["outdoor"]; !ok {
+		return
+	}
+	for _, v := range []float64{temperature, wind, solar} {
+		if math.IsNaN(v) {
+			return
+		}
+	}
+
+	rain, rainOK := history.Today()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	state.Rate = Hourly(temperature, humidity, wind, solar, pressure(), clearSkyRatio(t, solar))
+
+	interval := t.Sub(state.Last)
+	if state.Last.IsZero() || interval < 0 {
+		interval = 0
+	} else if interval > maxInterval {
+		interval = maxInterval
+	}
+	state.Last = t
+	amount := state.Rate * interval.Hours()
+
+	if hour := t.Truncate(time.Hour); !hour.Equal(state.Hour) {
+		if hour.Sub(state.Hour) == time.Hour {
+			state.LastHour = state.HourETo
+		} else {
+			state.LastHour = 0
+		}
+		state.Hour = hour
+		state.HourETo = 0
+	}
+	state.HourETo += amount
+
+	date := local.Format("2006-01-02")
+	if len(state.Days) == 0 || state.Days[len(state.Days)-1].Date != date {
+		state.Days = append(state.Days, Day{Date: date})
+		if len(state.Days) > keepDays {
+			state.Days = state.Days[len(state.Days)-keepDays:]
+		}
+	}
+	today := &state.Days[len(state.Days)-1]
+	today.ETo += amount
+	if rainOK && rain.Date == date {
+		today.Rain = rain.Rain
+	}
+
+	if err := save(); err != nil {
+		log.Printf("eto: unable to save %s: %v", filename(), err)
+	}
+}
+
+// save writes the state, the lock must be held
+func save() error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(config.Config.DataDir, 0755); err != nil {
+		return err
+	}
+
+	tmp := filename() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename())
+}
+
+// Today returns today's evapotranspiration and rain so far
+func Today() Day {
+	lock.Lock()
+	defer lock.Unlock()
+	return today()
+}
+
+func today() Day {
+	if len(state.Days) == 0 {
+		return Day{}
+	}
+	return state.Days[len(state.Days)-1]
+}
+
+// Date returns the totals for a single day given as YYYY-MM-DD, zero if the day is not kept
+func Date(date string) Day {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, day := range state.Days {
+		if day.Date == date {
+			return day
+		}
+	}
+	return Day{Date: date}
+}
+
+// Recent returns the totals for the days since, and including, the date given as YYYY-MM-DD
+func Recent(since string) Day {
+	lock.Lock()
+	defer lock.Unlock()
+	return recent(since)
+}
+
+func recent(since string) Day {
+	total := Day{Date: since}
+	i := sort.Search(len(state.Days), func(i int) bool { return state.Days[i].Date >= since })
+	for _, day := range state.Days[i:] {
+		total.ETo += day.ETo
+		total.Rain += day.Rain
+	}
+	return total
+}
+
+// WaterBalance returns rain minus ETo in mm over the last n days including today
+func WaterBalance(days int) float64 {
+	lock.Lock()
+	defer lock.Unlock()
+	return waterBalance(days)
+}
+
+func waterBalance(days int) float64 {
+	since := time.Now().In(config.Location()).AddDate(0, 0, 1-days).Format("2006-01-02")
+	total := recent(since)
+	return total.Rain - total.ETo
+}
+
+// Report adds the evapotranspiration and water balance to the exporter report, in mm
+func Report(report map[string]string) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if state.Last.IsZero() {
+		return
+	}
+
+	day := today()
+	report["ecowitt_eto_rate"] = fmt.Sprintf("%.3f", state.Rate)
+	report["ecowitt_eto_hourly"] = fmt.Sprintf("%.3f", state.LastHour)
+	report["ecowitt_eto_daily"] = fmt.Sprintf("%.2f", day.ETo)
+	if len(state.Days) > 1 {
+		report["ecowitt_eto_yesterday"] = fmt.Sprintf("%.2f", state.Days[len(state.Days)-2].ETo)
+	}
+	report["ecowitt_water_balance_daily"] = fmt.Sprintf("%.2f", day.Rain-day.ETo)
+	report["ecowitt_water_balance_7d"] = fmt.Sprintf("%.2f", waterBalance(7))
+}
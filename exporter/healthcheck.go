@@ -7,7 +7,7 @@ import (
 )
 
 func Healthcheck(w http.ResponseWriter, r *http.Request) {
-	switch ecowitt.WS.Status {
+	switch ecowitt.Snapshot().Status {
 	case ecowitt.Ready:
 		w.WriteHeader(200)
 		w.Write([]byte("OK"))
@@ -0,0 +1,34 @@
+package exporter
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"neverending.dev/weather/influx"
+	"neverending.dev/weather/metrics"
+)
+
+// ServeInflux renders the current report in InfluxDB line protocol, with a measurement for each
+// metric and its labels as tags
+func ServeInflux(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	lines := []string{}
+	for series, value := range generateWeatherReport() {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue // not representable in line protocol
+		}
+		name, labels := metrics.Parse(series)
+		lines = append(lines, influx.Line(name, labels, map[string]float64{"value": v}, now))
+	}
+	sort.Strings(lines)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
@@ -5,76 +5,123 @@ import (
 	"net/http"
 
 	"neverending.dev/weather/airgradient"
+	"neverending.dev/weather/alerts"
+	"neverending.dev/weather/cwop"
+	"neverending.dev/weather/degreedays"
 	"neverending.dev/weather/ecowitt"
+	"neverending.dev/weather/eto"
+	"neverending.dev/weather/frost"
+	"neverending.dev/weather/irrigation"
+	"neverending.dev/weather/lightning"
 	"neverending.dev/weather/measurement/Pressure"
 	"neverending.dev/weather/measurement/Rainfall"
 	"neverending.dev/weather/measurement/Temperature"
 	"neverending.dev/weather/measurement/Velocity"
+	"neverending.dev/weather/quality"
+	"neverending.dev/weather/records"
+	"neverending.dev/weather/relay"
+	"neverending.dev/weather/sensors"
+	"neverending.dev/weather/upload"
 )
 
 func generateWeatherReport() map[string]string {
 	report := make(map[string]string)
+	ws := ecowitt.Snapshot()
+	ag := airgradient.Snapshot()
+
+	if ws.Status == ecowitt.Ready {
+		gateway := sensors.Labels("ecowitt", "gateway")
+		outdoor := sensors.Labels("ecowitt", "outdoor")
+		lightning := sensors.Labels("ecowitt", "lightning")
 
-	if ecowitt.WS.Status == ecowitt.Ready {
 		// These values don't go into prometheus
 		// report["ecowitt_gw_timestamp"] = ecowitt.WS.Gateway.DateUTC
 		// report["ecowitt_gw_model"] = ecowitt.WS.Gateway.Model
 		// report["ecowitt_gw_station_type"] = ecowitt.WS.Gateway.StationType
-		report["ecowitt_gw_temperature"] = ecowitt.WS.Gateway.Temperature.ToStringAs(Temperature.Celsius)
-		report["ecowitt_gw_humidity"] = ecowitt.WS.Gateway.Humidity.ToString()
-		report["ecowitt_gw_pressure_rel"] = ecowitt.WS.Gateway.PressureRelative.ToStringAs(Pressure.Hectopascal)
-		report["ecowitt_gw_pressure_abs"] = ecowitt.WS.Gateway.PressureAbsolute.ToStringAs(Pressure.Hectopascal)
-
-		report["ecowitt_outdoor_temperature"] = ecowitt.WS.Outdoor.Temperature.ToStringAs(Temperature.Celsius)
-		report["ecowitt_outdoor_humidity"] = ecowitt.WS.Outdoor.Humidity.ToString()
-		report["ecowitt_outdoor_wind_speed"] = ecowitt.WS.Outdoor.WindSpeed.ToStringAs(Velocity.KilometresPerHour)
-		report["ecowitt_outdoor_wind_direction"] = fmt.Sprintf("%d", ecowitt.WS.Outdoor.WindDirection)
-		report["ecowitt_outdoor_wind_gust"] = ecowitt.WS.Outdoor.WindGust.ToStringAs(Velocity.KilometresPerHour)
-		report["ecowitt_outdoor_solar_radiation"] = fmt.Sprintf("%.2f", ecowitt.WS.Outdoor.SolarRadiation)
-		report["ecowitt_outdoor_uv"] = fmt.Sprintf("%d", ecowitt.WS.Outdoor.UV)
-		report["ecowitt_outdoor_rain_rate"] = ecowitt.WS.Outdoor.RainRate.ToStringAs(Rainfall.Millimetre)
-		report["ecowitt_outdoor_rain_event"] = ecowitt.WS.Outdoor.RainEvent.ToStringAs(Rainfall.Millimetre)
-		report["ecowitt_outdoor_rain_hourly"] = ecowitt.WS.Outdoor.RainHourly.ToStringAs(Rainfall.Millimetre)
-		report["ecowitt_outdoor_rain_daily"] = ecowitt.WS.Outdoor.RainDaily.ToStringAs(Rainfall.Millimetre)
-		report["ecowitt_outdoor_rain_weekly"] = ecowitt.WS.Outdoor.RainWeekly.ToStringAs(Rainfall.Millimetre)
-		report["ecowitt_outdoor_rain_monthly"] = ecowitt.WS.Outdoor.RainMonthly.ToStringAs(Rainfall.Millimetre)
-		report["ecowitt_outdoor_rain_yearly"] = ecowitt.WS.Outdoor.RainYearly.ToStringAs(Rainfall.Millimetre)
-		report["ecowitt_outdoor_rain_total"] = ecowitt.WS.Outdoor.RainTotal.ToStringAs(Rainfall.Millimetre)
-		report["ecowitt_outdoor_battery"] = fmt.Sprintf("%d", ecowitt.WS.Outdoor.Battery)
-
-		for i, sensor := range ecowitt.WS.TemperatureHumidity {
-			keystr := fmt.Sprintf("ecowitt_th_sensor_%d_temperature", i)
-			report[keystr] = sensor.Temperature.ToStringAs(Temperature.Celsius)
-			keystr = fmt.Sprintf("ecowitt_th_sensor_%d_humidity", i)
-			report[keystr] = sensor.Humidity.ToString()
-			keystr = fmt.Sprintf("ecowitt_th_sensor_%d_battery", i)
-			report[keystr] = fmt.Sprintf("%.2v", sensor.Battery)
+
+		report["ecowitt_gw_temperature"+gateway] = ws.Gateway.Temperature.ToStringAs(Temperature.Celsius)
+		report["ecowitt_gw_humidity"+gateway] = ws.Gateway.Humidity.ToString()
+		report["ecowitt_gw_pressure_rel"+gateway] = ws.Gateway.PressureRelative.ToStringAs(Pressure.Hectopascal)
+		report["ecowitt_gw_pressure_abs"+gateway] = ws.Gateway.PressureAbsolute.ToStringAs(Pressure.Hectopascal)
+
+		report["ecowitt_outdoor_temperature"+outdoor] = ws.Outdoor.Temperature.ToStringAs(Temperature.Celsius)
+		report["ecowitt_outdoor_humidity"+outdoor] = ws.Outdoor.Humidity.ToString()
+		report["ecowitt_outdoor_wind_speed"+outdoor] = ws.Outdoor.WindSpeed.ToStringAs(Velocity.KilometresPerHour)
+		report["ecowitt_outdoor_wind_direction"+outdoor] = fmt.Sprintf("%d", ws.Outdoor.WindDirection)
+		report["ecowitt_outdoor_wind_gust"+outdoor] = ws.Outdoor.WindGust.ToStringAs(Velocity.KilometresPerHour)
+		report["ecowitt_outdoor_solar_radiation"+outdoor] = fmt.Sprintf("%.2f", ws.Outdoor.SolarRadiation)
+		report["ecowitt_outdoor_uv"+outdoor] = fmt.Sprintf("%d", ws.Outdoor.UV)
+		report["ecowitt_outdoor_rain_rate"+outdoor] = ws.Outdoor.RainRate.ToStringAs(Rainfall.Millimetre)
+		report["ecowitt_outdoor_rain_event"+outdoor] = ws.Outdoor.RainEvent.ToStringAs(Rainfall.Millimetre)
+		report["ecowitt_outdoor_rain_hourly"+outdoor] = ws.Outdoor.RainHourly.ToStringAs(Rainfall.Millimetre)
+		report["ecowitt_outdoor_rain_daily"+outdoor] = ws.Outdoor.RainDaily.ToStringAs(Rainfall.Millimetre)
+		report["ecowitt_outdoor_rain_weekly"+outdoor] = ws.Outdoor.RainWeekly.ToStringAs(Rainfall.Millimetre)
+		report["ecowitt_outdoor_rain_monthly"+outdoor] = ws.Outdoor.RainMonthly.ToStringAs(Rainfall.Millimetre)
+		report["ecowitt_outdoor_rain_yearly"+outdoor] = ws.Outdoor.RainYearly.ToStringAs(Rainfall.Millimetre)
+		report["ecowitt_outdoor_rain_total"+outdoor] = ws.Outdoor.RainTotal.ToStringAs(Rainfall.Millimetre)
+		report["ecowitt_outdoor_battery"+outdoor] = fmt.Sprintf("%d", ws.Outdoor.Battery)
+
+		for _, sensor := range ws.TemperatureHumidity {
+			labels := sensors.Labels("ecowitt", fmt.Sprintf("th%d", sensor.ID), "channel", fmt.Sprintf("%d", sensor.ID))
+			report["ecowitt_th_sensor_temperature"+labels] = sensor.Temperature.ToStringAs(Temperature.Celsius)
+			report["ecowitt_th_sensor_humidity"+labels] = sensor.Humidity.ToString()
+			report["ecowitt_th_sensor_battery"+labels] = fmt.Sprintf("%.2v", sensor.Battery)
 		}
 
-		for i, sensor := range ecowitt.WS.SoilMoisture {
-			keystr := fmt.Sprintf("ecowitt_soil_sensor_%d_moisture", i)
-			report[keystr] = sensor.Moisture.ToString()
-			keystr = fmt.Sprintf("ecowitt_soil_sensor_%d_battery", i)
-			report[keystr] = fmt.Sprintf("%.2v", sensor.Battery)
+		for _, sensor := range ws.SoilMoisture {
+			labels := sensors.Labels("ecowitt", fmt.Sprintf("soil%d", sensor.ID), "channel", fmt.Sprintf("%d", sensor.ID))
+			report["ecowitt_soil_sensor_moisture"+labels] = sensor.Moisture.ToString()
+			report["ecowitt_soil_sensor_moisture_raw"+labels] = sensor.RawMoisture.ToString()
+			report["ecowitt_soil_sensor_ad"+labels] = fmt.Sprintf("%d", sensor.AD)
+			report["ecowitt_soil_sensor_battery"+labels] = fmt.Sprintf("%.2v", sensor.Battery)
 		}
 
-		report["ecowitt_lightning"] = fmt.Sprintf("%d", ecowitt.WS.Lightning.Distance)
-		report["ecowitt_lightning_count"] = fmt.Sprintf("%d", ecowitt.WS.Lightning.Count)
-		report["ecowitt_lightning_time"] = fmt.Sprintf("%d", ecowitt.WS.Lightning.Time)
-		report["ecowitt_lightning_battery"] = fmt.Sprintf("%d", ecowitt.WS.Lightning.Battery)
+		for _, sensor := range ws.Leak {
+			labels := sensors.Labels("ecowitt", fmt.Sprintf("leak%d", sensor.ID), "channel", fmt.Sprintf("%d", sensor.ID))
+			leak := 0
+			if sensor.Leak {
+				leak = 1
+			}
+			report["ecowitt_leak_sensor_leak"+labels] = fmt.Sprintf("%d", leak)
+			report["ecowitt_leak_sensor_battery"+labels] = fmt.Sprintf("%.2v", sensor.Battery)
+		}
+
+		report["ecowitt_lightning"+lightning] = fmt.Sprintf("%d", ws.Lightning.Distance)
+		report["ecowitt_lightning_count"+lightning] = fmt.Sprintf("%d", ws.Lightning.Count)
+		report["ecowitt_lightning_time"+lightning] = fmt.Sprintf("%d", ws.Lightning.Time)
+		report["ecowitt_lightning_battery"+lightning] = fmt.Sprintf("%d", ws.Lightning.Battery)
 	}
 
-	if airgradient.AG.Status == airgradient.Ready {
-		report["airgradient_rssi"] = fmt.Sprintf("%d", airgradient.AG.SignalStrength)
-		report["airgradient_temperature"] = airgradient.AG.Temperature.ToStringAs(Temperature.Celsius)
-		report["airgradient_humidity"] = airgradient.AG.Humidity.ToString()
-		report["airgradient_co2"] = fmt.Sprintf("%d", airgradient.AG.CO2)
-		report["airgradient_pm2dot5"] = fmt.Sprintf("%d", airgradient.AG.PM2dot5)
+	records.Report(report)
+	degreedays.Report(report)
+	eto.Report(report)
+	irrigation.Report(report)
+	frost.Report(report)
+	lightning.Report(report)
+	quality.Report(report)
+	sensors.Report(report)
+	alerts.Report(report)
+	upload.Report(report)
+	relay.Report(report)
+	cwop.Report(report)
+
+	if ag.Status == airgradient.Ready {
+		labels := sensors.Labels("airgradient", ag.ID)
+		report["airgradient_rssi"+labels] = fmt.Sprintf("%d", ag.SignalStrength)
+		report["airgradient_temperature"+labels] = ag.Temperature.ToStringAs(Temperature.Celsius)
+		report["airgradient_humidity"+labels] = ag.Humidity.ToString()
+		report["airgradient_co2"+labels] = fmt.Sprintf("%d", ag.CO2)
+		report["airgradient_pm2dot5"+labels] = fmt.Sprintf("%d", ag.PM2dot5)
 	}
 
 	return report
 }
 
+// Snapshot returns the current metrics keyed by series
+func Snapshot() map[string]string {
+	return generateWeatherReport()
+}
+
 func Serve(w http.ResponseWriter, r *http.Request) {
 	weatherReport := generateWeatherReport()
 	output := ""
@@ -0,0 +1,262 @@
+package frost
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/ecowitt"
+	"neverending.dev/weather/eto"
+	"neverending.dev/weather/measurement/Temperature"
+	"neverending.dev/weather/measurement/Velocity"
+)
+
+/*
+ * Frost risk for the coming hours. On clear, calm nights the ground loses heat by radiation and
+ * can be several degrees colder than the air at screen height, so the surface temperature is
+ * taken from ground level sensors when configured and otherwise estimated from the cloud cover
+ * of the last daytime reports and the wind. The surface minimum is projected from the current
+ * cooling rate, slowing as the night goes on, and limited by the dew point as condensation
+ * releases heat once the surface reaches it.
+ */
+
+const (
+	// trendWindow is how far back temperature samples are kept to calculate the cooling rate
+	trendWindow = 3 * time.Hour
+
+	// minTrend is the shortest span of samples the cooling rate is calculated over
+	minTrend = 30 * time.Minute
+
+	// coolingTime is the time constant of the slowing overnight cooling, the total further
+	// drop is at most the current rate for this many hours
+	coolingTime = 3.0
+
+	// radiativeDepression is how much colder the ground is than the air on a clear, calm night
+	radiativeDepression = 3.0 // °C
+
+	// night is the solar radiation below which the ground is losing heat, W/m2
+	night = 10.0
+)
+
+type Risk int
+
+const (
+	None Risk = iota
+	Low
+	Moderate
+	High
+)
+
+func (r Risk) String() string {
+	switch r {
+	case Low:
+		return "low"
+	case Moderate:
+		return "moderate"
+	case High:
+		return "high"
+	}
+	return "none"
+}
+
+// Status is the current frost risk, temperatures in °C
+type Status struct {
+	Risk        Risk      `json:"risk"`
+	Level       string    `json:"level"`
+	Temperature float64   `json:"temperature"`
+	DewPoint    float64   `json:"dew_point"`
+	Surface     float64   `json:"surface"`      // measured or estimated ground temperature
+	Measured    bool      `json:"measured"`     // the surface temperature is from ground sensors
+	CoolingRate float64   `json:"cooling_rate"` // °C per hour, negative when cooling
+	Projected   float64   `json:"projected"`    // lowest surface temperature expected over the horizon
+	Updated     time.Time `json:"updated"`
+}
+
+type sample struct {
+	time        time.Time
+	temperature float64
+}
+
+var samples = []sample{}
+var status = Status{}
+
+// lock guards samples and status, updated from reports while the metrics and API read them
+var lock sync.Mutex
+
+// current returns a copy of the status
+func current() Status {
+	lock.Lock()
+	defer lock.Unlock()
+	return status
+}
+
+// Start begins estimating the frost risk from ecowitt reports
+func Start() {
+	ecowitt.OnReport(Update)
+}
+
+// DewPoint returns the dew point in °C using the Magnus formula
+func DewPoint(temperature float64, humidity float64) float64 {
+	humidity = math.Max(1, humidity)
+	const a, b = 17.62, 243.12
+	gamma := math.Log(humidity/100) + a*temperature/(b+temperature)
+	return b * gamma / (a - gamma)
+}
+
+// rate returns the least squares slope of the samples in °C per hour, zero until the samples
+// cover long enough to be meaningful
+func rate(samples []sample) float64 {
+	if len(samples) < 2 || samples[len(samples)-1].time.Sub(samples[0].time) < minTrend {
+		return 0
+	}
+
+	start := samples[0].time
+	var sx, sy, sxx, sxy float64
+	for _, s := range samples {
+		x := s.time.Sub(start).Hours()
+		sx += x
+		sy += s.temperature
+		sxx += x * x
+		sxy += x * s.temperature
+	}
+
+	n := float64(len(samples))
+	denominator := n*sxx - sx*sx
+	if denominator == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / denominator
+}
+
+// ground returns the lowest reading of the ground level sensors, false if none are reporting
+func ground() (float64, bool) {
+	lowest, ok := 0.0, false
+	for _, source := range config.Config.Frost.Ground {
+		channel, _ := strconv.Atoi(strings.TrimPrefix(source, "th"))
+		for _, sensor := range ecowitt.WS.TemperatureHumidity {
+			if sensor.ID != channel {
+				continue
+			}
+			t := sensor.Temperature.Get(Temperature.Celsius)
+			if math.IsNaN(t) {
+				continue
+			}
+			if !ok || t < lowest {
+				lowest, ok = t, true
+			}
+		}
+	}
+	return lowest, ok
+}
+
+// depression returns how much colder the ground is expected to be than the air, from the
+// clearness of the sky and how calm the wind is
+func depression(wind float64, solar float64) float64 {
+	if solar >= night {
+		return 0
+	}
+	clearness := (eto.CloudRatio() - 0.25) / 0.75
+	calm := math.Max(0, math.Min(1, (4-wind)/3)) // 1 below 1 m/s, 0 above 4 m/s
+	return radiativeDepression * math.Max(0, clearness) * calm
+}
+
+func assess(surface float64, projected float64) Risk {
+	switch {
+	case surface <= 0 || projected <= -2:
+		return High
+	case projected <= 0:
+		return Moderate
+	case projected <= 2:
+		return Low
+	}
+	return None
+}
+
+// Update adds the latest outdoor temperature and reassesses the frost risk
+func Update() {
+	t := ecowitt.WS.ObservationTime()
+	outdoor := ecowitt.WS.Outdoor
+
+	air := outdoor.Temperature.Get(Temperature.Celsius)
+	humidity := float64(outdoor.Humidity.Get())
+	wind := outdoor.WindSpeed.Get(Velocity.MetresPerSecond)
+
+	// a reading the outdoor sensor array has not reported would carry NaN into the cooling rate.
+	// Humidity has no NaN, it is 0 until the array has reported.
+	if _, ok := ecowitt.WS.LastSeen["outdoor"]; !ok {
+		return
+	}
+	for _, v := range []float64{air, wind, outdoor.SolarRadiation} {
+		if math.IsNaN(v) {
+			return
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	kept := []sample{}
+	for _, s := range samples {
+		if t.Sub(s.time) < trendWindow {
+			kept = append(kept, s)
+		}
+	}
+	samples = append(kept, sample{time: t, temperature: air})
+
+	s := Status{
+		Temperature: air,
+		DewPoint:    DewPoint(air, humidity),
+		CoolingRate: rate(samples),
+		Updated:     t,
+	}
+
+	drop := depression(wind, outdoor.SolarRadiation)
+	s.Surface, s.Measured = ground()
+	if !s.Measured {
+		s.Surface = air - drop
+	}
+
+	s.Projected = s.Surface
+	if s.CoolingRate < 0 {
+		hours := config.Config.Frost.Hours()
+		s.Projected += s.CoolingRate * coolingTime * (1 - math.Exp(-hours/coolingTime))
+
+		// Condensation at the dew point slows further cooling of the surface
+		floor := s.DewPoint - drop
+		if s.Projected < floor {
+			s.Projected = math.Min(s.Surface, floor)
+		}
+	}
+
+	s.Risk = assess(s.Surface, s.Projected)
+	s.Level = s.Risk.String()
+	status = s
+}
+
+// Report adds the frost risk to the exporter report, temperatures in °C
+func Report(report map[string]string) {
+	status := current()
+	if status.Updated.IsZero() {
+		return
+	}
+
+	report["weather_frost_risk"] = fmt.Sprintf("%d", status.Risk)
+	report["weather_frost_dew_point"] = fmt.Sprintf("%.1f", status.DewPoint)
+	report["weather_frost_surface_temperature"] = fmt.Sprintf("%.1f", status.Surface)
+	report["weather_frost_cooling_rate"] = fmt.Sprintf("%.2f", status.CoolingRate)
+	report["weather_frost_projected_min"] = fmt.Sprintf("%.1f", status.Projected)
+}
+
+// Handler serves the frost risk as JSON
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(current()); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
@@ -0,0 +1,123 @@
+package graphite
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/readings"
+	"neverending.dev/weather/sensors"
+)
+
+/*
+ * Sends each reading to Graphite in the plaintext protocol as it is received, one line of path,
+ * value and timestamp per reading. Over TCP the connection is kept open and reconnected when it
+ * fails, readings sent while Carbon is unreachable are dropped. Sending happens apart from the
+ * report handlers so a slow server never holds up a station.
+ */
+
+const (
+	timeout = 5 * time.Second
+
+	// queued is the number of reports waiting to be sent before further reports are dropped
+	queued = 100
+)
+
+var unsafe = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+var reports = make(chan []string, queued)
+var conn net.Conn
+
+// Start sends the readings after each report
+func Start() {
+	if config.Config.Graphite.Address == "" {
+		return
+	}
+
+	readings.OnReport(func(received []readings.Reading) {
+		c := config.Config.Graphite
+		lines := []string{}
+		for _, r := range received {
+			value := strconv.FormatFloat(r.Value, 'f', -1, 64)
+			lines = append(lines, fmt.Sprintf("%s %s %d\n", Path(c.Prefix, c.Template, r), value, r.Time.Unix()))
+		}
+
+		select {
+		case reports <- lines:
+		default:
+			log.Printf("graphite: %s is not keeping up, dropping %d readings", c.Address, len(lines))
+		}
+	})
+	go run()
+}
+
+// Path returns the dotted path of a reading. The template's {station}, {sensor}, {field},
+// {quantity}, {name} and {zone} are replaced, with anything but letters, digits, - and _ in them
+// replaced by _. Empty nodes are left out.
+func Path(prefix string, template string, r readings.Reading) string {
+	name, zone := r.Sensor, ""
+	if s, ok := sensors.Lookup(r.Station, r.Sensor); ok {
+		if s.Name != "" {
+			name = s.Name
+		}
+		zone = s.Zone
+	}
+
+	replacer := strings.NewReplacer(
+		"{station}", unsafe.ReplaceAllString(r.Station, "_"),
+		"{sensor}", unsafe.ReplaceAllString(r.Sensor, "_"),
+		"{field}", unsafe.ReplaceAllString(r.Field, "_"),
+		"{quantity}", unsafe.ReplaceAllString(r.Quantity, "_"),
+		"{name}", unsafe.ReplaceAllString(name, "_"),
+		"{zone}", unsafe.ReplaceAllString(zone, "_"),
+	)
+
+	nodes := []string{}
+	for _, node := range strings.Split(prefix+"."+replacer.Replace(template), ".") {
+		if node != "" {
+			nodes = append(nodes, node)
+		}
+	}
+	return strings.Join(nodes, ".")
+}
+
+func run() {
+	for lines := range reports {
+		if err := send(lines); err != nil {
+			log.Printf("graphite: unable to send to %s: %v", config.Config.Graphite.Address, err)
+		}
+	}
+}
+
+func send(lines []string) error {
+	c := config.Config.Graphite
+	if conn == nil {
+		var err error
+		if conn, err = net.DialTimeout(c.Protocol, c.Address, timeout); err != nil {
+			conn = nil
+			return err
+		}
+	}
+
+	// Each UDP datagram holds a single line so none is split across packets
+	writes := []string{strings.Join(lines, "")}
+	if c.Protocol == "udp" {
+		writes = lines
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(timeout))
+	for _, data := range writes {
+		// a failed socket is not reused, the next report connects again
+		if _, err := conn.Write([]byte(data)); err != nil {
+			conn.Close()
+			conn = nil
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package history
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/ecowitt"
+	"neverending.dev/weather/measurement/Rainfall"
+	"neverending.dev/weather/measurement/Temperature"
+	"neverending.dev/weather/measurement/Velocity"
+	"neverending.dev/weather/records"
+)
+
+// Sectors is the number of compass sectors wind direction is counted in
+const Sectors = 16
+
+// Day holds the summary of a single day's outdoor readings in the configured timezone. Values are
+// stored in metric units (°C, km/h, mm) so the history is independent of the presentation units.
+type Day struct {
+	Date            string          `json:"date"` // YYYY-MM-DD
+	Samples         int             `json:"samples"`
+	TemperatureSum  float64         `json:"temperature_sum"`
+	HighTemperature records.Record  `json:"high_temperature"`
+	LowTemperature  records.Record  `json:"low_temperature"`
+	Rain            float64         `json:"rain"`
+	RainTotal       float64         `json:"rain_total"` // last totalrainin seen, used to calculate Rain
+	WindSpeedSum    float64         `json:"wind_speed_sum"`
+	HighGust        records.Record  `json:"high_gust"`
+	Direction       [Sectors]uint64 `json:"direction"` // samples per compass sector while the wind is blowing
+}
+
+// MeanTemperature returns the average of all temperature samples for the day
+func (d Day) MeanTemperature() Temperature.Temperature {
+	return Temperature.New(d.TemperatureSum/float64(d.Samples), Temperature.Celsius)
+}
+
+// MeanWindSpeed returns the average of all wind speed samples for the day
+func (d Day) MeanWindSpeed() Velocity.Velocity {
+	return Velocity.New(d.WindSpeedSum/float64(d.Samples), Velocity.KilometresPerHour)
+}
+
+// Dominant returns the centre of the compass sector the wind blew from most often, and false if
+// the wind never blew
+func Dominant(direction [Sectors]uint64) (int64, bool) {
+	best := -1
+	for i, count := range direction {
+		if count > 0 && (best < 0 || count > direction[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return 0, false
+	}
+	return int64(best * 360 / Sectors), true
+}
+
+// Month holds the daily summaries for a month, persisted as a single file
+type Month struct {
+	Days []Day `json:"days"`
+}
+
+var current = Month{}
+var currentMonth = ""
+
+// lock guards current and currentMonth, updated from reports while the summaries are served
+var lock sync.Mutex
+
+func filename(month string) string {
+	return filepath.Join(config.Config.DataDir, "history", month+".json")
+}
+
+// Load returns the history for the month, given as YYYY-MM. A month with no history is empty.
+func Load(month string) (Month, error) {
+	lock.Lock()
+	if month == currentMonth {
+		m := Month{Days: append([]Day{}, current.Days...)}
+		lock.Unlock()
+		return m, nil
+	}
+	lock.Unlock()
+
+	return load(month)
+}
+
+// load reads the history for the month from its file
+func load(month string) (Month, error) {
+	var m Month
+	data, err := os.ReadFile(filename(month))
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return m, err
+	}
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+// Today returns the summary of the most recent day reported, false if nothing has been reported
+func Today() (Day, bool) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if len(current.Days) == 0 {
+		return Day{}, false
+	}
+	return current.Days[len(current.Days)-1], true
+}
+
+// save writes the current month, the lock must be held
+func save() error {
+	data, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename(currentMonth)), 0755); err != nil {
+		return err
+	}
+
+	tmp := filename(currentMonth) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename(currentMonth))
+}
+
+// Start begins recording reports from the ecowitt gateway
+func Start() {
+	ecowitt.OnReport(Update)
+}
+
+// Update adds the current ecowitt outdoor readings to the day they were observed
+func Update() {
+	t := ecowitt.WS.ObservationTime().In(config.Location())
+
+	temperature := ecowitt.WS.Outdoor.Temperature.Get(Temperature.Celsius)
+	windSpeed := ecowitt.WS.Outdoor.WindSpeed.Get(Velocity.KilometresPerHour)
+	gust := ecowitt.WS.Outdoor.WindGust.Get(Velocity.KilometresPerHour)
+	rainTotal := ecowitt.WS.Outdoor.RainTotal.Get(Rainfall.Millimetre)
+
+	// the means share the sample count, so a sample is only counted when both were reported
+	if math.IsNaN(temperature) || math.IsNaN(windSpeed) {
+		return
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if month := t.Format("2006-01"); month != currentMonth {
+		m, err := load(month)
+		if err != nil {
+			log.Printf("history: unable to load %s: %v", filename(month), err)
+		}
+		current = m
+		currentMonth = month
+	}
+
+	date := t.Format("2006-01-02")
+	if len(current.Days) == 0 || current.Days[len(current.Days)-1].Date != date {
+		current.Days = append(current.Days, Day{Date: date, RainTotal: lastRainTotal()})
+	}
+	day := &current.Days[len(current.Days)-1]
+
+	day.Samples++
+	day.TemperatureSum += temperature
+	if !day.HighTemperature.Valid() || temperature > day.HighTemperature.Value {
+		day.HighTemperature = records.Record{Value: temperature, Time: t}
+	}
+	if !day.LowTemperature.Valid() || temperature < day.LowTemperature.Value {
+		day.LowTemperature = records.Record{Value: temperature, Time: t}
+	}
+
+	// totalrainin only ever increases unless the gateway is reset, so ignore decreases
+	if !math.IsNaN(rainTotal) {
+		if day.RainTotal > 0 && rainTotal > day.RainTotal {
+			day.Rain += rainTotal - day.RainTotal
+		}
+		day.RainTotal = rainTotal
+	}
+
+	day.WindSpeedSum += windSpeed
+	if !math.IsNaN(gust) && (!day.HighGust.Valid() || gust > day.HighGust.Value) {
+		direction := ecowitt.WS.Outdoor.WindDirection
+		day.HighGust = records.Record{Value: gust, Time: t, Direction: &direction}
+	}
+	if windSpeed > 0 {
+		sector := ((ecowitt.WS.Outdoor.WindDirection*Sectors + 180) / 360) % Sectors
+		day.Direction[sector]++
+	}
+
+	if err := save(); err != nil {
+		log.Printf("history: unable to save %s: %v", filename(currentMonth), err)
+	}
+}
+
+// lastRainTotal returns the most recent totalrainin recorded, looking back into the previous
+// month's history when the current month has just started. The lock must be held.
+func lastRainTotal() float64 {
+	if len(current.Days) > 0 {
+		return current.Days[len(current.Days)-1].RainTotal
+	}
+
+	t, err := time.ParseInLocation("2006-01", currentMonth, config.Location())
+	if err != nil {
+		return 0
+	}
+	previous, err := load(t.AddDate(0, -1, 0).Format("2006-01"))
+	if err != nil || len(previous.Days) == 0 {
+		return 0
+	}
+	return previous.Days[len(previous.Days)-1].RainTotal
+}
@@ -0,0 +1,247 @@
+package influx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/readings"
+	"neverending.dev/weather/sensors"
+)
+
+/*
+ * Writes each reading to InfluxDB as it is received, one point per sensor with a field for each
+ * reading, timestamped with the time the report was received. Points are sent in batches, when a
+ * batch is full or the flush interval has passed. While the database is unavailable the points
+ * are kept on disk, up to the buffer size, and sent once it is back.
+ */
+
+var queue = []string{}
+var failing bool
+var lock sync.Mutex
+var wake = make(chan struct{}, 1)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// permanentError is a write the database rejected, which would be rejected again if retried
+type permanentError struct {
+	error
+}
+
+func filename() string {
+	return filepath.Join(config.Config.DataDir, "influx.buffer")
+}
+
+// Start loads any points buffered during an outage and writes the readings after each report
+func Start() {
+	if config.Config.Influx.URL == "" {
+		return
+	}
+
+	if data, err := os.ReadFile(filename()); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line != "" {
+				queue = append(queue, line)
+			}
+		}
+		failing = len(queue) > 0
+	}
+
+	readings.OnReport(add)
+	go run()
+}
+
+// Points returns a line for each sensor in the readings, with the sensor's configured description
+// as tags
+func Points(measurement string, received []readings.Reading) []string {
+	points := map[string]map[string]float64{}
+	times := map[string]time.Time{}
+	order := []readings.Reading{}
+	for _, r := range received {
+		key := r.Station + "/" + r.Sensor
+		if _, ok := points[key]; !ok {
+			points[key] = map[string]float64{}
+			times[key] = r.Time
+			order = append(order, r)
+		}
+		points[key][r.Field] = r.Value
+	}
+
+	lines := []string{}
+	for _, r := range order {
+		tags := sensors.Tags(r.Station, r.Sensor)
+		tags["station"] = r.Station
+		tags["sensor"] = r.Sensor
+		key := r.Station + "/" + r.Sensor
+		lines = append(lines, Line(measurement, tags, points[key], times[key]))
+	}
+	return lines
+}
+
+// add queues the points of a report, dropping the oldest once the buffer is full
+func add(received []readings.Reading) {
+	c := config.Config.Influx
+	lines := Points(c.Measurement, received)
+	if len(lines) == 0 {
+		return
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	queue = append(queue, lines...)
+	if dropped := len(queue) - c.BufferSize; dropped > 0 {
+		log.Printf("influx: buffer full, dropping %d points", dropped)
+		queue = queue[dropped:]
+	}
+
+	if failing {
+		if err := save(); err != nil {
+			log.Printf("influx: unable to save %s: %v", filename(), err)
+		}
+	}
+
+	if len(queue) >= c.BatchSize {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run sends the queued points each flush interval, or sooner once a batch is full
+func run() {
+	ticker := time.NewTicker(config.Config.Influx.Interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-wake:
+		}
+		flush()
+	}
+}
+
+// flush sends batches until the queue is empty or a write fails
+func flush() {
+	c := config.Config.Influx
+	for {
+		lock.Lock()
+		n := len(queue)
+		if n > c.BatchSize {
+			n = c.BatchSize
+		}
+		batch := queue[:n:n]
+		queue = queue[n:]
+		lock.Unlock()
+
+		if len(batch) == 0 {
+			break
+		}
+
+		err := write(batch)
+		if _, ok := err.(permanentError); ok {
+			log.Printf("influx: dropping %d points: %v", len(batch), err)
+			continue
+		}
+		if err != nil {
+			log.Printf("influx: unable to write, will retry: %v", err)
+
+			lock.Lock()
+			queue = append(batch, queue...)
+			if dropped := len(queue) - c.BufferSize; dropped > 0 {
+				queue = queue[dropped:]
+			}
+			failing = true
+			if err := save(); err != nil {
+				log.Printf("influx: unable to save %s: %v", filename(), err)
+			}
+			lock.Unlock()
+			return
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if failing && len(queue) == 0 {
+		failing = false
+		if err := os.Remove(filename()); err != nil && !os.IsNotExist(err) {
+			log.Printf("influx: unable to remove %s: %v", filename(), err)
+		}
+	}
+}
+
+// endpoint returns the write URL for the configured version
+func endpoint() string {
+	c := config.Config.Influx
+	base := strings.TrimSuffix(c.URL, "/")
+	params := url.Values{}
+	params.Set("precision", "ns")
+	if c.Version == 1 {
+		params.Set("db", c.Database)
+		if c.RetentionPolicy != "" {
+			params.Set("rp", c.RetentionPolicy)
+		}
+		return base + "/write?" + params.Encode()
+	}
+	params.Set("org", c.Org)
+	params.Set("bucket", c.Bucket)
+	return base + "/api/v2/write?" + params.Encode()
+}
+
+func write(batch []string) error {
+	c := config.Config.Influx
+	body := strings.Join(batch, "\n") + "\n"
+	req, err := http.NewRequest("POST", endpoint(), bytes.NewBufferString(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	switch {
+	case c.Version == 2 && c.Token != "":
+		req.Header.Set("Authorization", "Token "+c.Token)
+	case c.Username != "":
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s", resp.Status)
+	if len(bytes.TrimSpace(message)) > 0 {
+		err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return permanentError{err}
+	}
+	return err
+}
+
+// save writes the queue to disk, so points are not lost if the exporter restarts during an outage
+func save() error {
+	if err := os.MkdirAll(config.Config.DataDir, 0755); err != nil {
+		return err
+	}
+
+	tmp := filename() + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(queue, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename())
+}
@@ -0,0 +1,63 @@
+package influx
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * InfluxDB line protocol, a measurement with its tags followed by the fields and a timestamp in
+ * nanoseconds, e.g. weather,sensor=outdoor,station=ecowitt temperature=21.5 1700000000000000000
+ */
+
+// Line protocol has no escape for newlines, they end the point, so they are written as spaces
+var measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\ `, "\r", `\ `)
+var tagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\ `, "\r", `\ `)
+
+// Line returns a point in line protocol, tags and fields are sorted by name and empty tag values
+// are left out
+func Line(measurement string, tags map[string]string, fields map[string]float64, t time.Time) string {
+	var b strings.Builder
+	b.WriteString(measurementEscaper.Replace(measurement))
+
+	for _, name := range sorted(tags) {
+		if tags[name] == "" {
+			continue
+		}
+		b.WriteString(",")
+		b.WriteString(tagEscaper.Replace(name))
+		b.WriteString("=")
+		b.WriteString(tagEscaper.Replace(tags[name]))
+	}
+
+	names := []string{}
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if i == 0 {
+			b.WriteString(" ")
+		} else {
+			b.WriteString(",")
+		}
+		b.WriteString(tagEscaper.Replace(name))
+		b.WriteString("=")
+		b.WriteString(strconv.FormatFloat(fields[name], 'f', -1, 64))
+	}
+
+	b.WriteString(" ")
+	b.WriteString(strconv.FormatInt(t.UnixNano(), 10))
+	return b.String()
+}
+
+func sorted(m map[string]string) []string {
+	names := []string{}
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
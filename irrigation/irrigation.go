@@ -0,0 +1,204 @@
+package irrigation
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/ecowitt"
+	"neverending.dev/weather/eto"
+	"neverending.dev/weather/metrics"
+)
+
+/*
+ * Irrigation advice for each configured zone. The soil moisture sensor shows the water available
+ * now, the expected use over the next day is the larger of the current drying trend and the crop
+ * evapotranspiration (Kc x ETo). Rain that has fallen today is treated as not yet reaching the
+ * sensor, so it reduces the deficit.
+ */
+
+// trendWindow is how far back soil moisture samples are kept to calculate the trend
+const trendWindow = 6 * time.Hour
+
+type State string
+
+const (
+	Unknown    State = "unknown"
+	OK         State = "ok"
+	NeedsWater State = "needs_water"
+	Wet        State = "wet"
+)
+
+type sample struct {
+	time     time.Time
+	moisture float64
+}
+
+// Status is the current advice for a zone
+type Status struct {
+	Zone      string    `json:"zone"`
+	State     State     `json:"state"`
+	Moisture  float64   `json:"moisture"`  // %
+	Trend     float64   `json:"trend"`     // % per hour
+	Projected float64   `json:"projected"` // % expected in 24 hours without watering
+	CropETc   float64   `json:"crop_etc"`  // mm per day
+	Rain      float64   `json:"rain"`      // mm today
+	Deficit   float64   `json:"deficit"`   // mm to reach target_max
+	Minutes   float64   `json:"minutes"`   // minutes of watering to make up the deficit
+	Updated   time.Time `json:"updated"`
+}
+
+var samples = map[string][]sample{}
+var statuses = map[string]Status{}
+
+// lock guards samples and statuses, updated from reports while the metrics and API read them
+var lock sync.Mutex
+
+// Start begins advising on the configured zones from ecowitt reports
+func Start() {
+	for _, zone := range config.Config.Irrigation {
+		statuses[zone.Name] = Status{Zone: zone.Name, State: Unknown}
+	}
+
+	ecowitt.OnReport(Update)
+}
+
+// trend returns the least squares slope of the samples in % per hour
+func trend(samples []sample) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+
+	start := samples[0].time
+	var sx, sy, sxx, sxy float64
+	for _, s := range samples {
+		x := s.time.Sub(start).Hours()
+		sx += x
+		sy += s.moisture
+		sxx += x * x
+		sxy += x * s.moisture
+	}
+
+	n := float64(len(samples))
+	denominator := n*sxx - sx*sx
+	if denominator == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / denominator
+}
+
+// cropETc returns the expected daily crop water use in mm, from yesterday's ETo when available as
+// today's is only a partial day
+func cropETc(zone config.Zone) float64 {
+	kc := zone.CropCoefficient
+	if kc == 0 {
+		kc = 1.0
+	}
+
+	yesterday := time.Now().In(config.Location()).AddDate(0, 0, -1).Format("2006-01-02")
+	daily := eto.Date(yesterday).ETo
+	if daily == 0 {
+		daily = eto.Today().ETo
+	}
+	return kc * daily
+}
+
+func advise(zone config.Zone, moisture float64, t time.Time) Status {
+	status := Status{
+		Zone:     zone.Name,
+		Moisture: moisture,
+		Trend:    trend(samples[zone.Name]),
+		CropETc:  cropETc(zone),
+		Rain:     eto.Today().Rain,
+		Updated:  t,
+	}
+
+	// Convert the crop water use to a drop in volumetric moisture across the root zone
+	etcDrop := status.CropETc / zone.RootDepth * 100
+	drop := math.Max(-status.Trend*24, etcDrop)
+	status.Projected = moisture - drop
+
+	status.Deficit = math.Max(0, (zone.TargetMax-moisture)/100*zone.RootDepth-status.Rain)
+
+	switch {
+	case moisture > zone.TargetMax:
+		status.State = Wet
+	case status.Deficit > 0 && (moisture < zone.TargetMin || status.Projected < zone.TargetMin):
+		status.State = NeedsWater
+		status.Minutes = status.Deficit / zone.ApplicationRate * 60
+	default:
+		status.State = OK
+	}
+
+	return status
+}
+
+// Update adds the latest soil moisture readings and updates the advice for each zone
+func Update() {
+	t := ecowitt.WS.ObservationTime()
+
+	lock.Lock()
+	defer lock.Unlock()
+	for _, zone := range config.Config.Irrigation {
+		for _, sensor := range ecowitt.WS.SoilMoisture {
+			if sensor.ID != zone.SoilChannel {
+				continue
+			}
+
+			moisture := float64(sensor.Moisture.Get())
+			kept := []sample{}
+			for _, s := range samples[zone.Name] {
+				if t.Sub(s.time) < trendWindow {
+					kept = append(kept, s)
+				}
+			}
+			samples[zone.Name] = append(kept, sample{time: t, moisture: moisture})
+
+			statuses[zone.Name] = advise(zone, moisture, t)
+		}
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Report adds the advice for each zone to the exporter report
+func Report(report map[string]string) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for name, status := range statuses {
+		if status.State == Unknown {
+			continue
+		}
+		labels := "{" + metrics.Label("zone", name) + "}"
+		report["irrigation_needs_water"+labels] = fmt.Sprintf("%d", boolToInt(status.State == NeedsWater))
+		report["irrigation_minutes_to_water"+labels] = fmt.Sprintf("%.1f", status.Minutes)
+		report["irrigation_deficit"+labels] = fmt.Sprintf("%.2f", status.Deficit)
+		report["irrigation_moisture_trend"+labels] = fmt.Sprintf("%.3f", status.Trend)
+		report["irrigation_moisture_projected"+labels] = fmt.Sprintf("%.1f", status.Projected)
+	}
+}
+
+// Handler serves the advice for every zone as JSON
+func Handler(w http.ResponseWriter, r *http.Request) {
+	zones := []Status{}
+	lock.Lock()
+	for _, zone := range config.Config.Irrigation {
+		zones = append(zones, statuses[zone.Name])
+	}
+	lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(zones); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
@@ -0,0 +1,255 @@
+package lightning
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/ecowitt"
+)
+
+/*
+ * Storm tracking from the WH57 lightning sensor. The sensor reports a running daily strike count
+ * with the distance and time of the last strike, so new strikes are found from the change in the
+ * count between reports. Strikes over the last 30 minutes give the closest strike and whether the
+ * storm is approaching or retreating. It is all clear once there has been no strike within the
+ * configured radius for the all clear period.
+ */
+
+const (
+	// window is how long strikes are kept for the strike count, closest strike and trend
+	window = 30 * time.Minute
+
+	// steady is the change in distance, km/h, below which the storm is neither approaching nor
+	// retreating
+	steady = 5.0
+)
+
+// Strike is one or more strikes found in a single report, at the distance of the last
+type Strike struct {
+	Time     time.Time `json:"time"`
+	Distance float64   `json:"distance"` // km
+	Count    uint64    `json:"count"`
+}
+
+// State is persisted across restarts
+type State struct {
+	Count    uint64    `json:"count"`    // lightning_num at the previous report
+	Counted  bool      `json:"counted"`  // a previous count has been seen
+	Interval uint64    `json:"interval"` // strikes since the previous report
+	Strikes  []Strike  `json:"strikes"`  // oldest first
+	Nearby   time.Time `json:"nearby"`   // time of the last strike within the radius
+}
+
+// Status is the current state of the storm
+type Status struct {
+	Interval   uint64     `json:"interval"`          // strikes since the previous report
+	Strikes    uint64     `json:"strikes"`           // strikes in the last 30 minutes
+	Closest    *float64   `json:"closest,omitempty"` // km, closest strike in the last 30 minutes
+	Trend      float64    `json:"trend"`             // km/h, negative when approaching
+	Movement   string     `json:"movement"`          // approaching, retreating, steady or none
+	LastNearby *time.Time `json:"last_nearby,omitempty"`
+	AllClear   bool       `json:"all_clear"`
+	Remaining  float64    `json:"remaining"` // seconds until all clear
+}
+
+var state = State{}
+
+// lock guards state, updated from reports while the metrics and API read it
+var lock sync.Mutex
+
+// snapshot returns a copy of the state
+func snapshot() State {
+	lock.Lock()
+	defer lock.Unlock()
+
+	s := state
+	s.Strikes = append([]Strike{}, state.Strikes...)
+	return s
+}
+
+func filename() string {
+	return filepath.Join(config.Config.DataDir, "lightning.json")
+}
+
+// Start loads the persisted strikes and begins tracking from ecowitt reports
+func Start() {
+	if data, err := os.ReadFile(filename()); err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			log.Printf("lightning: unable to load %s: %v", filename(), err)
+		}
+	}
+
+	ecowitt.OnReport(Update)
+}
+
+// Update finds the strikes since the previous report
+func Update() {
+	if _, ok := ecowitt.WS.LastSeen["lightning"]; !ok {
+		return
+	}
+
+	sensor := ecowitt.WS.Lightning
+	t := ecowitt.WS.ObservationTime()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	// The count is reset each day by the gateway
+	state.Interval = 0
+	if state.Counted {
+		state.Interval = sensor.Count - state.Count
+		if sensor.Count < state.Count {
+			state.Interval = sensor.Count
+		}
+	}
+	state.Count = sensor.Count
+	state.Counted = true
+
+	if state.Interval > 0 {
+		strike := Strike{Time: t, Distance: float64(sensor.Distance), Count: state.Interval}
+		if sensor.Time > 0 {
+			strike.Time = time.Unix(int64(sensor.Time), 0).UTC()
+		}
+		state.Strikes = append(state.Strikes, strike)
+		if strike.Distance <= config.Config.Lightning.Radius && strike.Time.After(state.Nearby) {
+			state.Nearby = strike.Time
+		}
+	}
+
+	kept := []Strike{}
+	for _, strike := range state.Strikes {
+		if t.Sub(strike.Time) < window {
+			kept = append(kept, strike)
+		}
+	}
+	state.Strikes = kept
+
+	if err := save(); err != nil {
+		log.Printf("lightning: unable to save %s: %v", filename(), err)
+	}
+}
+
+// save writes the state, the lock must be held
+func save() error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(config.Config.DataDir, 0755); err != nil {
+		return err
+	}
+
+	tmp := filename() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename())
+}
+
+// trend returns the least squares change in distance of the strikes in km/h
+func trend(strikes []Strike) float64 {
+	if len(strikes) < 2 {
+		return 0
+	}
+
+	start := strikes[0].Time
+	var sx, sy, sxx, sxy float64
+	for _, s := range strikes {
+		x := s.Time.Sub(start).Hours()
+		sx += x
+		sy += s.Distance
+		sxx += x * x
+		sxy += x * s.Distance
+	}
+
+	n := float64(len(strikes))
+	denominator := n*sxx - sx*sx
+	if denominator == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / denominator
+}
+
+// Current returns the state of the storm at now
+func Current(now time.Time) Status {
+	return snapshot().status(now)
+}
+
+// status returns the state of the storm at now from the strikes kept
+func (s State) status(now time.Time) Status {
+	status := Status{Interval: s.Interval, Movement: "none", AllClear: true}
+
+	recent := []Strike{}
+	for _, strike := range s.Strikes {
+		if now.Sub(strike.Time) >= window {
+			continue
+		}
+		recent = append(recent, strike)
+		status.Strikes += strike.Count
+		if status.Closest == nil || strike.Distance < *status.Closest {
+			closest := strike.Distance
+			status.Closest = &closest
+		}
+	}
+
+	if len(recent) > 1 {
+		status.Trend = trend(recent)
+		switch {
+		case status.Trend < -steady:
+			status.Movement = "approaching"
+		case status.Trend > steady:
+			status.Movement = "retreating"
+		default:
+			status.Movement = "steady"
+		}
+	}
+
+	if !s.Nearby.IsZero() {
+		nearby := s.Nearby
+		status.LastNearby = &nearby
+		if remaining := config.Config.Lightning.Wait() - now.Sub(nearby); remaining > 0 {
+			status.AllClear = false
+			status.Remaining = math.Ceil(remaining.Seconds())
+		}
+	}
+
+	return status
+}
+
+// Report adds the storm tracking to the exporter report, distances in km
+func Report(report map[string]string) {
+	s := snapshot()
+	if !s.Counted {
+		return
+	}
+
+	status := s.status(time.Now())
+	report["weather_lightning_strikes_interval"] = fmt.Sprintf("%d", status.Interval)
+	report["weather_lightning_strikes_30m"] = fmt.Sprintf("%d", status.Strikes)
+	if status.Closest != nil {
+		report["weather_lightning_closest_30m"] = fmt.Sprintf("%.0f", *status.Closest)
+	}
+	report["weather_lightning_trend"] = fmt.Sprintf("%.1f", status.Trend)
+	report["weather_lightning_all_clear"] = "0"
+	if status.AllClear {
+		report["weather_lightning_all_clear"] = "1"
+	}
+	report["weather_lightning_all_clear_remaining"] = fmt.Sprintf("%.0f", status.Remaining)
+}
+
+// Handler serves the state of the storm as JSON
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(Current(time.Now())); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
@@ -1,21 +1,104 @@
 package main
 
 import (
+	"embed"
+	"flag"
+	"io/fs"
 	"log"
 	"net/http"
+	_ "time/tzdata"
 
 	"neverending.dev/weather/airgradient"
+	"neverending.dev/weather/alerts"
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/current"
+	"neverending.dev/weather/cwop"
+	"neverending.dev/weather/degreedays"
 	"neverending.dev/weather/ecowitt"
+	"neverending.dev/weather/eto"
 	"neverending.dev/weather/exporter"
+	"neverending.dev/weather/frost"
+	"neverending.dev/weather/graphite"
+	"neverending.dev/weather/history"
+	"neverending.dev/weather/influx"
+	"neverending.dev/weather/irrigation"
+	"neverending.dev/weather/lightning"
+	"neverending.dev/weather/mqtt"
+	"neverending.dev/weather/noaa"
+	"neverending.dev/weather/otlp"
+	"neverending.dev/weather/quality"
+	"neverending.dev/weather/records"
+	"neverending.dev/weather/relay"
+	"neverending.dev/weather/remotewrite"
+	"neverending.dev/weather/sensors"
+	"neverending.dev/weather/statsd"
+	"neverending.dev/weather/stream"
+	"neverending.dev/weather/timeline"
+	"neverending.dev/weather/upload"
 )
 
+// dist is the dashboard, built into the binary so it does not depend on the working directory
+//
+//go:embed dist
+var dist embed.FS
+
 func main() {
+	configFile := flag.String("config", "weather.json", "path to the JSON configuration file")
+	flag.Parse()
+
+	if err := config.Load(*configFile); err != nil {
+		log.Fatalf("Unable to load configuration %s: %v", *configFile, err)
+	}
+
+	if flag.Arg(0) == "noaa" {
+		if err := noaa.Command(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	quality.Start()
+	records.Start()
+	history.Start()
+	degreedays.Start()
+	eto.Start()
+	irrigation.Start()
+	frost.Start()
+	lightning.Start()
+	alerts.Start(exporter.Snapshot)
+	mqtt.Start()
+	influx.Start()
+	remotewrite.Start()
+	otlp.Start()
+	graphite.Start()
+	statsd.Start()
+	stream.Start()
+	timeline.Start()
+	upload.Start()
+	relay.Start()
+	cwop.Start()
 
-	http.Handle("/", http.FileServer(http.Dir("./dist")))
+	static, err := fs.Sub(dist, "dist")
+	if err != nil {
+		log.Fatal(err)
+	}
+	http.Handle("/", http.FileServer(http.FS(static)))
 	http.HandleFunc("/healthz", exporter.Healthcheck)
 	http.HandleFunc("/metrics", exporter.Serve)
+	http.HandleFunc("/metrics.influx", exporter.ServeInflux)
 	http.HandleFunc("/weather", ecowitt.ReportHandler)
 	http.HandleFunc("/airgradient", airgradient.ReportHandler)
+	http.HandleFunc("/api/v1/current", current.Handler)
+	http.HandleFunc("/api/v1/stations/", current.StationHandler)
+	http.HandleFunc("/api/v1/stream", stream.Handler)
+	http.HandleFunc("/api/v1/timeline", timeline.Handler)
+	http.HandleFunc("/api/v1/records", records.Handler)
+	http.HandleFunc("/api/v1/noaa", noaa.Handler)
+	http.HandleFunc("/api/v1/irrigation", irrigation.Handler)
+	http.HandleFunc("/api/v1/frost", frost.Handler)
+	http.HandleFunc("/api/v1/lightning", lightning.Handler)
+	http.HandleFunc("/api/v1/sensors", sensors.Handler)
+	http.HandleFunc("/api/v1/alerts", alerts.Handler)
 
-	log.Fatal(http.ListenAndServe(":8090", nil))
+	log.Fatal(http.ListenAndServe(config.Config.Listen, nil))
 }
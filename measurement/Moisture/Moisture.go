@@ -2,6 +2,7 @@ package Moisture
 
 import (
 	"fmt"
+	"math"
 )
 
 type Moisture struct {
@@ -30,3 +31,34 @@ func (d Moisture) ToString() string {
 func (d Moisture) ToFullString() string {
 	return fmt.Sprintf("%d%s", d.value, "%")
 }
+
+// Point maps a raw sensor reading to a moisture percentage
+type Point struct {
+	Raw      float64 `json:"raw"`
+	Moisture float64 `json:"moisture"`
+}
+
+// Curve is a piecewise linear calibration from raw sensor readings to moisture. Points must be in
+// order of raw reading, readings beyond either end are extrapolated from the nearest segment.
+type Curve []Point
+
+// Apply returns the calibrated moisture for a raw reading, limited to 0-100%
+func (c Curve) Apply(raw float64) Moisture {
+	if len(c) < 2 {
+		return New(0)
+	}
+
+	i := 1
+	for i < len(c)-1 && raw > c[i].Raw {
+		i++
+	}
+	a, b := c[i-1], c[i]
+
+	value := b.Moisture
+	if b.Raw != a.Raw {
+		value = a.Moisture + (raw-a.Raw)*(b.Moisture-a.Moisture)/(b.Raw-a.Raw)
+	}
+	value = math.Max(0, math.Min(100, value))
+
+	return New(int64(math.Round(value)))
+}
@@ -3,6 +3,9 @@ package Pressure
 import (
 	"fmt"
 	"math"
+	"strings"
+
+	"neverending.dev/weather/measurement"
 )
 
 type Unit int8
@@ -18,17 +21,54 @@ const (
 func (u Unit) String() string {
 	switch u {
 	case Pascal:
-		return "P"
+		return "Pa"
 	case Hectopascal:
-		return "hP"
+		return "hPa"
 	case Kilopascal:
-		return "kP"
+		return "kPa"
 	case InchOfMercury:
-		return "InHg"
+		return "inHg"
 	}
 	return "unknown"
 }
 
+// UCUM returns the symbol of the unit in the Unified Code for Units of Measure
+func (u Unit) UCUM() string {
+	switch u {
+	case Pascal:
+		return "Pa"
+	case Hectopascal:
+		return "hPa"
+	case Kilopascal:
+		return "kPa"
+	case InchOfMercury:
+		return "[in_i'Hg]"
+	}
+	return ""
+}
+
+// aliases are alternative names accepted by Parse in addition to each unit's String()
+var aliases = map[string]Unit{
+	"p":    Pascal,
+	"hp":   Hectopascal,
+	"mbar": Hectopascal,
+	"kp":   Kilopascal,
+}
+
+// Parse returns the unit named by s, matching either the unit symbol or a common name
+func Parse(s string) (Unit, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, u := range []Unit{Pascal, Hectopascal, Kilopascal, InchOfMercury} {
+		if s == strings.ToLower(u.String()) {
+			return u, nil
+		}
+	}
+	if u, ok := aliases[s]; ok {
+		return u, nil
+	}
+	return Undefined, fmt.Errorf("unknown pressure unit %q", s)
+}
+
 type Pressure struct {
 	value float64
 	unit  Unit
@@ -48,6 +88,11 @@ func (d Pressure) Set(value float64, unit Unit) {
 	d.unit = unit
 }
 
+// Unit returns the unit the value was given in
+func (d Pressure) Unit() Unit {
+	return d.unit
+}
+
 func (d Pressure) Get(unit Unit) float64 {
 	switch unit {
 	case Pascal:
@@ -100,3 +145,17 @@ func toInchOfMercury(value float64, from Unit) float64 {
 
 	return math.NaN()
 }
+
+// FromString parses a pressure written with its unit, e.g. "1013.2 hPa"
+func FromString(s string) (Pressure, error) {
+	value, unit, err := measurement.Split(s, "pressure")
+	if err != nil {
+		return Pressure{}, err
+	}
+	u, err := Parse(unit)
+	if err != nil {
+		return Pressure{}, err
+	}
+
+	return New(value, u), nil
+}
@@ -3,6 +3,9 @@ package Rainfall
 import (
 	"fmt"
 	"math"
+	"strings"
+
+	"neverending.dev/weather/measurement"
 )
 
 type Unit int8
@@ -26,6 +29,39 @@ func (u Unit) String() string {
 	return "unknown"
 }
 
+// UCUM returns the symbol of the unit in the Unified Code for Units of Measure
+func (u Unit) UCUM() string {
+	switch u {
+	case Millimetre:
+		return "mm"
+	case Centimetre:
+		return "cm"
+	case Inch:
+		return "[in_i]"
+	}
+	return ""
+}
+
+// aliases are alternative names accepted by Parse in addition to each unit's String()
+var aliases = map[string]Unit{
+	"inch":   Inch,
+	"inches": Inch,
+}
+
+// Parse returns the unit named by s, matching either the unit symbol or a common name
+func Parse(s string) (Unit, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, u := range []Unit{Millimetre, Centimetre, Inch} {
+		if s == strings.ToLower(u.String()) {
+			return u, nil
+		}
+	}
+	if u, ok := aliases[s]; ok {
+		return u, nil
+	}
+	return Undefined, fmt.Errorf("unknown rainfall unit %q", s)
+}
+
 type Rainfall struct {
 	value float64
 	unit  Unit
@@ -44,6 +80,11 @@ func (d Rainfall) Set(value float64, unit Unit) {
 	d.unit = unit
 }
 
+// Unit returns the unit the value was given in
+func (d Rainfall) Unit() Unit {
+	return d.unit
+}
+
 func (d Rainfall) Get(unit Unit) float64 {
 	switch unit {
 	case Millimetre:
@@ -90,3 +131,17 @@ func toInch(value float64, from Unit) float64 {
 
 	return math.NaN()
 }
+
+// FromString parses a rainfall written with its unit, e.g. "0.2 mm"
+func FromString(s string) (Rainfall, error) {
+	value, unit, err := measurement.Split(s, "rainfall")
+	if err != nil {
+		return Rainfall{}, err
+	}
+	u, err := Parse(unit)
+	if err != nil {
+		return Rainfall{}, err
+	}
+
+	return New(value, u), nil
+}
@@ -3,6 +3,9 @@ package Temperature
 import (
 	"fmt"
 	"math"
+	"strings"
+
+	"neverending.dev/weather/measurement"
 )
 
 type Unit int8
@@ -26,6 +29,43 @@ func (u Unit) String() string {
 	return "unknown"
 }
 
+// UCUM returns the symbol of the unit in the Unified Code for Units of Measure
+func (u Unit) UCUM() string {
+	switch u {
+	case Kelvin:
+		return "K"
+	case Celsius:
+		return "Cel"
+	case Farenheit:
+		return "[degF]"
+	}
+	return ""
+}
+
+// aliases are alternative names accepted by Parse in addition to each unit's String()
+var aliases = map[string]Unit{
+	"c":          Celsius,
+	"celsius":    Celsius,
+	"f":          Farenheit,
+	"fahrenheit": Farenheit,
+	"farenheit":  Farenheit,
+	"kelvin":     Kelvin,
+}
+
+// Parse returns the unit named by s, matching either the unit symbol or a common name
+func Parse(s string) (Unit, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, u := range []Unit{Kelvin, Celsius, Farenheit} {
+		if s == strings.ToLower(u.String()) {
+			return u, nil
+		}
+	}
+	if u, ok := aliases[s]; ok {
+		return u, nil
+	}
+	return Undefined, fmt.Errorf("unknown temperature unit %q", s)
+}
+
 type Temperature struct {
 	value float64
 	unit  Unit
@@ -41,6 +81,11 @@ func (d Temperature) Set(value float64, unit Unit) {
 	d.unit = unit
 }
 
+// Unit returns the unit the value was given in
+func (d Temperature) Unit() Unit {
+	return d.unit
+}
+
 func (d Temperature) Get(unit Unit) float64 {
 	switch unit {
 	case Celsius:
@@ -100,3 +145,23 @@ func toKelvin(value float64, from Unit) float64 {
 
 	return math.NaN()
 }
+
+// FromString parses a temperature written with its unit, e.g. "18 °C", "65F" or "-0.8 °C"
+func FromString(s string) (Temperature, error) {
+	value, unit, err := measurement.Split(s, "temperature")
+	if err != nil {
+		return Temperature{}, err
+	}
+	u, err := Parse(unit)
+	if err != nil {
+		return Temperature{}, err
+	}
+
+	return New(value, u), nil
+}
+
+// Delta converts a temperature difference between units. Unlike Get no offset is applied, a
+// difference of 1 °C is a difference of 1.8 °F.
+func Delta(value float64, from Unit, to Unit) float64 {
+	return New(value, from).Get(to) - New(0, from).Get(to)
+}
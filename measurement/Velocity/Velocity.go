@@ -3,6 +3,9 @@ package Velocity
 import (
 	"fmt"
 	"math"
+	"strings"
+
+	"neverending.dev/weather/measurement"
 )
 
 type Unit int8
@@ -26,6 +29,40 @@ func (u Unit) String() string {
 	return "unknown"
 }
 
+// UCUM returns the symbol of the unit in the Unified Code for Units of Measure
+func (u Unit) UCUM() string {
+	switch u {
+	case MetresPerSecond:
+		return "m/s"
+	case KilometresPerHour:
+		return "km/h"
+	case MilesPerHour:
+		return "[mi_i]/h"
+	}
+	return ""
+}
+
+// aliases are alternative names accepted by Parse in addition to each unit's String()
+var aliases = map[string]Unit{
+	"kph": KilometresPerHour,
+	"kmh": KilometresPerHour,
+	"ms":  MetresPerSecond,
+}
+
+// Parse returns the unit named by s, matching either the unit symbol or a common name
+func Parse(s string) (Unit, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, u := range []Unit{MetresPerSecond, KilometresPerHour, MilesPerHour} {
+		if s == strings.ToLower(u.String()) {
+			return u, nil
+		}
+	}
+	if u, ok := aliases[s]; ok {
+		return u, nil
+	}
+	return Undefined, fmt.Errorf("unknown velocity unit %q", s)
+}
+
 type Velocity struct {
 	value float64
 	unit  Unit
@@ -44,6 +81,11 @@ func (d Velocity) Set(value float64, unit Unit) {
 	d.unit = unit
 }
 
+// Unit returns the unit the value was given in
+func (d Velocity) Unit() Unit {
+	return d.unit
+}
+
 func (d Velocity) Get(unit Unit) float64 {
 	switch unit {
 	case MetresPerSecond:
@@ -90,3 +132,17 @@ func toMilesPerHour(value float64, from Unit) float64 {
 
 	return math.NaN()
 }
+
+// FromString parses a velocity written with its unit, e.g. "1.5 km/h"
+func FromString(s string) (Velocity, error) {
+	value, unit, err := measurement.Split(s, "velocity")
+	if err != nil {
+		return Velocity{}, err
+	}
+	u, err := Parse(unit)
+	if err != nil {
+		return Velocity{}, err
+	}
+
+	return New(value, u), nil
+}
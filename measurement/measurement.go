@@ -0,0 +1,30 @@
+package measurement
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Measurement holds what the quantities with units share. Each quantity parses its own unit, the
+ * value in front of it is split off here.
+ */
+
+// Split separates a value written with its unit, e.g. "-0.8 °C", into the value and the unit.
+// The quantity names the value in errors.
+func Split(s string, quantity string) (float64, string, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return !strings.ContainsRune("+-.0123456789", r)
+	})
+	if i <= 0 {
+		return 0, "", fmt.Errorf("invalid %s %q, expected a value and unit", quantity, s)
+	}
+
+	value, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid %s %q: %v", quantity, s, err)
+	}
+	return value, s[i:], nil
+}
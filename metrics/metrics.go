@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * The exporter report is keyed by Prometheus series, a metric name followed by its labels, e.g.
+ * ecowitt_th_sensor_temperature{channel="1",name="Office"}. Parse splits a series back into the
+ * name and labels for the packages that send metrics elsewhere.
+ */
+
+// Parse returns the metric name and labels of a series
+func Parse(series string) (string, map[string]string) {
+	labels := map[string]string{}
+	open := strings.IndexByte(series, '{')
+	if open < 0 || !strings.HasSuffix(series, "}") {
+		return series, labels
+	}
+
+	name := series[:open]
+	rest := series[open+1 : len(series)-1]
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			break
+		}
+		label := rest[:eq]
+		rest = rest[eq+1:]
+
+		end := closingQuote(rest)
+		if end < 0 {
+			break
+		}
+		labels[label], _ = strconv.Unquote(rest[:end+1])
+		rest = strings.TrimPrefix(rest[end+1:], ",")
+	}
+	return name, labels
+}
+
+// closingQuote returns the index of the quote ending the quoted string s starts with, -1 if s
+// does not start with a complete quoted string
+func closingQuote(s string) int {
+	if !strings.HasPrefix(s, `"`) {
+		return -1
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// Names returns the label names in order
+func Names(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// labelEscaper escapes a label value as the Prometheus text format does, only backslashes, double
+// quotes and newlines are escaped
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Label returns a label of a series, e.g. name="Office"
+func Label(name string, value string) string {
+	return name + `="` + labelEscaper.Replace(value) + `"`
+}
+
+// Series returns the series of a metric name and labels, with the labels in order
+func Series(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	pairs := []string{}
+	for _, label := range Names(labels) {
+		pairs = append(pairs, Label(label, labels[label]))
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
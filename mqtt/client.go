@@ -0,0 +1,377 @@
+package mqtt
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * A minimal MQTT 3.1.1 client, publishing and subscribing at QoS 0. The client reconnects with a
+ * backoff when the connection is lost, resubscribing and calling OnConnect so retained state can
+ * be published again.
+ *
+ * MQTT Version 3.1.1, OASIS Standard, 29 October 2014
+ */
+
+// control packet types
+const (
+	connect   = 1
+	connack   = 2
+	publish   = 3
+	subscribe = 8
+	pingreq   = 12
+)
+
+const (
+	maxBackoff  = 2 * time.Minute
+	dialTimeout = 10 * time.Second
+)
+
+// Handler is called with each message received on a subscribed topic
+type Handler func(topic string, payload []byte)
+
+// Client is a connection to an MQTT broker
+type Client struct {
+	Broker    string // tcp://host:1883, ssl://host:8883 or mqtts://host:8883
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration
+
+	// Will is published by the broker with retain when the client disconnects unexpectedly
+	WillTopic   string
+	WillPayload []byte
+
+	// OnConnect is called each time the client connects
+	OnConnect func(c *Client)
+
+	lock          sync.Mutex
+	conn          net.Conn
+	subscriptions map[string]Handler
+	packetID      uint16
+}
+
+// Run connects to the broker and handles incoming messages, reconnecting until the program exits
+func (c *Client) Run() {
+	backoff := time.Second
+	for {
+		start := time.Now()
+		if err := c.session(); err != nil {
+			log.Printf("mqtt: %s: %v", c.Broker, err)
+		}
+
+		// A connection that stayed up for a while starts the backoff again
+		if time.Since(start) > maxBackoff {
+			backoff = time.Second
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// Publish sends a message, it is dropped if the client is not connected
+func (c *Client) Publish(topic string, payload []byte, retain bool) error {
+	header := byte(publish << 4)
+	if retain {
+		header |= 0x01
+	}
+
+	body := appendString(nil, topic)
+	body = append(body, payload...)
+	return c.write(header, body)
+}
+
+// Subscribe adds a handler for messages on the topic filter, which may include + and # wildcards
+func (c *Client) Subscribe(filter string, handler Handler) error {
+	c.lock.Lock()
+	if c.subscriptions == nil {
+		c.subscriptions = map[string]Handler{}
+	}
+	c.subscriptions[filter] = handler
+	connected := c.conn != nil
+	c.lock.Unlock()
+
+	if !connected {
+		return nil
+	}
+	return c.subscribe(filter)
+}
+
+func (c *Client) subscribe(filter string) error {
+	c.lock.Lock()
+	c.packetID++
+	if c.packetID == 0 {
+		c.packetID = 1
+	}
+	id := c.packetID
+	c.lock.Unlock()
+
+	body := []byte{byte(id >> 8), byte(id)}
+	body = appendString(body, filter)
+	body = append(body, 0) // QoS 0
+	return c.write(subscribe<<4|0x02, body)
+}
+
+// session connects and reads from the broker until the connection is lost
+func (c *Client) session() error {
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	if err := c.handshake(conn, r); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	c.conn = conn
+	filters := []string{}
+	for filter := range c.subscriptions {
+		filters = append(filters, filter)
+	}
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		c.conn = nil
+		c.lock.Unlock()
+	}()
+
+	for _, filter := range filters {
+		if err := c.subscribe(filter); err != nil {
+			return err
+		}
+	}
+	if c.OnConnect != nil {
+		go c.OnConnect(c)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go c.ping(done)
+
+	for {
+		if c.KeepAlive > 0 {
+			conn.SetReadDeadline(time.Now().Add(c.KeepAlive * 3 / 2))
+		}
+		header, body, err := readPacket(r)
+		if err != nil {
+			return err
+		}
+		if header>>4 == publish {
+			c.receive(header, body)
+		}
+	}
+}
+
+func (c *Client) dial() (net.Conn, error) {
+	u, err := url.Parse(c.Broker)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		return net.DialTimeout("tcp", hostPort(u.Host, "1883"), dialTimeout)
+	case "ssl", "tls", "mqtts":
+		dialer := &net.Dialer{Timeout: dialTimeout}
+		return tls.DialWithDialer(dialer, "tcp", hostPort(u.Host, "8883"), &tls.Config{ServerName: u.Hostname()})
+	}
+	return nil, fmt.Errorf("unknown scheme %q", u.Scheme)
+}
+
+func hostPort(host string, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// handshake sends CONNECT and waits for the broker to accept it
+func (c *Client) handshake(conn net.Conn, r *bufio.Reader) error {
+	flags := byte(0x02) // clean session
+	payload := appendString(nil, c.ClientID)
+	if c.WillTopic != "" {
+		flags |= 0x04 | 0x20 // will, retained at QoS 0
+		payload = appendString(payload, c.WillTopic)
+		payload = appendBytes(payload, c.WillPayload)
+	}
+	if c.Username != "" {
+		flags |= 0x80
+		payload = appendString(payload, c.Username)
+	}
+	if c.Password != "" {
+		flags |= 0x40
+		payload = appendString(payload, c.Password)
+	}
+
+	keepAlive := uint16(c.KeepAlive / time.Second)
+	body := appendString(nil, "MQTT")
+	body = append(body, 4, flags, byte(keepAlive>>8), byte(keepAlive))
+	body = append(body, payload...)
+
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := conn.Write(packet(connect<<4, body)); err != nil {
+		return err
+	}
+	header, ack, err := readPacket(r)
+	if err != nil {
+		return err
+	}
+	if header>>4 != connack || len(ack) != 2 {
+		return errors.New("unexpected response to connect")
+	}
+	if ack[1] != 0 {
+		return fmt.Errorf("connection refused, return code %d", ack[1])
+	}
+	return nil
+}
+
+// ping keeps the connection alive until done is closed
+func (c *Client) ping(done chan struct{}) {
+	if c.KeepAlive <= 0 {
+		return
+	}
+	ticker := time.NewTicker(c.KeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.write(pingreq<<4, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) receive(header byte, body []byte) {
+	if len(body) < 2 {
+		return
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+n {
+		return
+	}
+	topic := string(body[2 : 2+n])
+	payload := body[2+n:]
+	if (header>>1)&0x03 > 0 {
+		if len(payload) < 2 {
+			return
+		}
+		payload = payload[2:] // packet identifier, only sent for QoS 1 and 2
+	}
+
+	c.lock.Lock()
+	handlers := []Handler{}
+	for filter, handler := range c.subscriptions {
+		if Match(filter, topic) {
+			handlers = append(handlers, handler)
+		}
+	}
+	c.lock.Unlock()
+
+	for _, handler := range handlers {
+		handler(topic, payload)
+	}
+}
+
+func (c *Client) write(header byte, body []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.conn == nil {
+		return errors.New("not connected")
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	_, err := c.conn.Write(packet(header, body))
+	return err
+}
+
+// Match reports whether a topic matches a subscription filter
+func Match(filter string, topic string) bool {
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) || (level != "+" && level != t[i]) {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
+
+func appendString(b []byte, s string) []byte {
+	return appendBytes(b, []byte(s))
+}
+
+func appendBytes(b []byte, data []byte) []byte {
+	b = append(b, byte(len(data)>>8), byte(len(data)))
+	return append(b, data...)
+}
+
+// packet returns a control packet with the fixed header and remaining length
+func packet(header byte, body []byte) []byte {
+	p := []byte{header}
+	n := len(body)
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		p = append(p, digit)
+		if n == 0 {
+			break
+		}
+	}
+	return append(p, body...)
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
@@ -0,0 +1,170 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"neverending.dev/weather/airgradient"
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/ecowitt"
+	"neverending.dev/weather/measurement/Pressure"
+	"neverending.dev/weather/measurement/Rainfall"
+	"neverending.dev/weather/measurement/Temperature"
+	"neverending.dev/weather/measurement/Velocity"
+)
+
+/*
+ * Readings received over MQTT, from sensors such as Tasmota or ESPHome nodes, are converted to
+ * the fields and units the station's own reports use and ingested the same way, so they pass
+ * through calibration and the quality checks and appear in every output.
+ */
+
+// metricUnits are the units readings are given in when the mapping has no unit
+var metricUnits = map[string]string{
+	"°F": "°C", "inHg": "hPa", "mph": "km/h", "in": "mm",
+}
+
+// ingestSubscriptions adds a handler for each configured subscription
+func ingestSubscriptions(c *Client) {
+	for _, sub := range config.Config.MQTT.Subscriptions {
+		sub := sub
+		c.Subscribe(sub.Topic, func(topic string, payload []byte) {
+			if err := ingest(sub, payload); err != nil {
+				log.Printf("mqtt: unable to ingest %s: %v", topic, err)
+			}
+		})
+	}
+}
+
+// lookup returns the value at a dot separated path in a JSON message, array elements are given
+// by their index
+func lookup(message interface{}, path string) (float64, error) {
+	value := message
+	if path != "" {
+		for _, key := range strings.Split(path, ".") {
+			switch v := value.(type) {
+			case map[string]interface{}:
+				value = v[key]
+			case []interface{}:
+				i, err := strconv.Atoi(key)
+				if err != nil || i < 0 || i >= len(v) {
+					return 0, fmt.Errorf("%s: no element %s", path, key)
+				}
+				value = v[i]
+			default:
+				return 0, fmt.Errorf("%s: not found", path)
+			}
+		}
+	}
+
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("%s: not a number", path)
+}
+
+// convert returns the value in the unit the station reports in
+func convert(value float64, from string, to string) (float64, error) {
+	if to == "" {
+		return value, nil
+	}
+	if from == "" {
+		from = metricUnits[to]
+		if from == "" {
+			from = to
+		}
+	}
+
+	switch to {
+	case "°F", "°C":
+		f, err := Temperature.Parse(from)
+		if err != nil {
+			return 0, err
+		}
+		t, _ := Temperature.Parse(to)
+		return Temperature.New(value, f).Get(t), nil
+	case "inHg":
+		f, err := Pressure.Parse(from)
+		if err != nil {
+			return 0, err
+		}
+		return Pressure.New(value, f).Get(Pressure.InchOfMercury), nil
+	case "mph":
+		f, err := Velocity.Parse(from)
+		if err != nil {
+			return 0, err
+		}
+		return Velocity.New(value, f).Get(Velocity.MilesPerHour), nil
+	case "in":
+		f, err := Rainfall.Parse(strings.TrimSuffix(from, "/h")) // rain rates are per hour
+		if err != nil {
+			return 0, err
+		}
+		return Rainfall.New(value, f).Get(Rainfall.Inch), nil
+	}
+	return 0, fmt.Errorf("unknown unit %q", to)
+}
+
+// ingest maps a message to report fields and updates the station
+func ingest(sub config.Subscription, payload []byte) error {
+	var message interface{}
+	if err := json.Unmarshal(payload, &message); err != nil {
+		// A plain value that is not JSON is used as it is
+		message = strings.TrimSpace(string(payload))
+	}
+
+	fields := map[string]string{}
+	for _, f := range sub.Fields {
+		t, err := sub.Target(f)
+		if err != nil {
+			continue
+		}
+		value, err := lookup(message, f.Path)
+		if err != nil {
+			return err
+		}
+		if value, err = convert(value, f.Unit, t.Unit); err != nil {
+			return err
+		}
+
+		fields[t.Field] = strconv.FormatFloat(value, 'f', -1, 64)
+		if t.Integer {
+			fields[t.Field] = fmt.Sprintf("%d", int64(math.Round(value)))
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	if sub.Station == "airgradient" {
+		airgradient.Ingest(airgradient.AirGradientJSON{
+			ID:             sub.Sensor,
+			SignalStrength: fields["wifi"],
+			PM2dot5:        fields["pm02"],
+			CO2:            fields["rco2"],
+			Temperature:    fields["atmp"],
+			Humidity:       fields["rhum"],
+		})
+		return nil
+	}
+
+	form := url.Values{}
+	for field, value := range fields {
+		form.Set(field, value)
+	}
+	ecowitt.Ingest(form, false)
+	return nil
+}
@@ -0,0 +1,284 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/ecowitt"
+)
+
+// message is a PUBLISH or SUBSCRIBE received by the test broker
+type message struct {
+	topic     string
+	payload   string
+	retain    bool
+	subscribe bool
+}
+
+// broker is an in-process MQTT broker accepting a single client. It records what the client
+// publishes and subscribes to, keeping the last message on each topic as the retained state.
+type broker struct {
+	listener net.Listener
+	conn     net.Conn
+	connect  []byte
+	received chan message
+	retained map[string]message
+	filters  []string
+}
+
+func newBroker(t *testing.T) *broker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &broker{listener: l, received: make(chan message, 1000), retained: map[string]message{}}
+}
+
+// accept waits for the client to connect, accepts its CONNECT and reads what it sends
+func (b *broker) accept(t *testing.T) {
+	t.Helper()
+	b.listener.(*net.TCPListener).SetDeadline(time.Now().Add(10 * time.Second))
+	conn, err := b.listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.conn = conn
+	r := bufio.NewReader(conn)
+
+	header, body, err := readPacket(r)
+	if err != nil || header>>4 != connect {
+		t.Fatalf("expected CONNECT, got %x: %v", header, err)
+	}
+	b.connect = body
+	if _, err := conn.Write([]byte{connack << 4, 2, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			header, body, err := readPacket(r)
+			if err != nil {
+				return
+			}
+			switch header >> 4 {
+			case publish:
+				topic := lengthPrefixed(body)[0]
+				b.received <- message{topic: topic, payload: string(body[2+len(topic):]), retain: header&0x01 == 1}
+			case subscribe:
+				b.received <- message{topic: lengthPrefixed(body[2:])[0], subscribe: true}
+				conn.Write([]byte{9 << 4, 3, body[0], body[1], 0}) // SUBACK
+			}
+		}
+	}()
+}
+
+// publish sends a message to the client
+func (b *broker) publish(t *testing.T, topic string, payload string) {
+	t.Helper()
+	body := appendString(nil, topic)
+	if _, err := b.conn.Write(packet(publish<<4, append(body, payload...))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// wait reads what the client sends until it has published to topic, or subscribed to it when
+// subscribe is set, returning the message
+func (b *broker) wait(t *testing.T, topic string, subscribe bool) message {
+	t.Helper()
+	if m, ok := b.retained[topic]; ok && !subscribe {
+		return m
+	}
+	for _, filter := range b.filters {
+		if filter == topic && subscribe {
+			return message{topic: topic, subscribe: true}
+		}
+	}
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case m := <-b.received:
+			if m.subscribe {
+				b.filters = append(b.filters, m.topic)
+			} else {
+				b.retained[m.topic] = m
+			}
+			if m.topic == topic && m.subscribe == subscribe {
+				return m
+			}
+		case <-timeout:
+			t.Fatalf("nothing received on %s", topic)
+		}
+	}
+}
+
+// lengthPrefixed returns the strings of a packet body, each preceded by its length
+func lengthPrefixed(body []byte) []string {
+	s := []string{}
+	for len(body) >= 2 {
+		n := int(binary.BigEndian.Uint16(body))
+		if len(body) < 2+n {
+			break
+		}
+		s = append(s, string(body[2:2+n]))
+		body = body[2+n:]
+	}
+	return s
+}
+
+// decode returns the JSON object of a message
+func decode(t *testing.T, m message) map[string]interface{} {
+	t.Helper()
+	v := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(m.payload), &v); err != nil {
+		t.Fatalf("%s: %v: %s", m.topic, err, m.payload)
+	}
+	return v
+}
+
+func TestBroker(t *testing.T) {
+	b := newBroker(t)
+	defer b.listener.Close()
+
+	config.Config.MQTT = config.MQTT{
+		Broker:          "tcp://" + b.listener.Addr().String(),
+		ClientID:        "weather-test",
+		Username:        "weather",
+		Password:        "secret",
+		StateTopic:      "weather/{station}/{sensor}",
+		ValueTopic:      "weather/{station}/{sensor}/{field}",
+		Status:          "weather/status",
+		Discovery:       true,
+		DiscoveryPrefix: "homeassistant",
+		Subscriptions: []config.Subscription{{
+			Topic:   "tasmota/tele/+/SENSOR",
+			Station: "ecowitt",
+			Sensor:  "th2",
+			Fields:  []config.FieldMapping{{Path: "AM2301.Temperature", Quantity: "temperature", Unit: "°C"}},
+		}},
+	}
+	Start()
+	b.accept(t)
+	defer b.conn.Close()
+
+	t.Run("connect", func(t *testing.T) {
+		// protocol name and level 4, then the flags for a username, password, will retained at
+		// QoS 0 and a clean session
+		if name := lengthPrefixed(b.connect[:6]); len(name) != 1 || name[0] != "MQTT" || b.connect[6] != 4 {
+			t.Fatalf("CONNECT protocol %q level %d", b.connect[:6], b.connect[6])
+		}
+		if flags := b.connect[7]; flags != 0x80|0x40|0x20|0x04|0x02 {
+			t.Errorf("CONNECT flags = %08b", flags)
+		}
+		payload := lengthPrefixed(b.connect[10:])
+		want := []string{"weather-test", "weather/status", "offline", "weather", "secret"}
+		if len(payload) != len(want) {
+			t.Fatalf("CONNECT payload = %q, want %q", payload, want)
+		}
+		for i := range want {
+			if payload[i] != want[i] {
+				t.Errorf("CONNECT payload = %q, want %q", payload, want)
+			}
+		}
+
+		if m := b.wait(t, "weather/status", false); m.payload != "online" || !m.retain {
+			t.Errorf("status %q retained %v, want online retained", m.payload, m.retain)
+		}
+		b.wait(t, "tasmota/tele/+/SENSOR", true)
+	})
+
+	ecowitt.Ingest(url.Values{
+		"stationtype":  {"GW2000A_V3.1.0"},
+		"tempinf":      {"68.0"},
+		"humidityin":   {"45"},
+		"baromrelin":   {"29.921"},
+		"baromabsin":   {"29.500"},
+		"tempf":        {"50.0"},
+		"humidity":     {"60"},
+		"windspeedmph": {"0.00"},
+		"dailyrainin":  {"0.100"},
+	}, true)
+
+	t.Run("state", func(t *testing.T) {
+		m := b.wait(t, "weather/ecowitt/outdoor", false)
+		if !m.retain {
+			t.Error("state not retained")
+		}
+		state := decode(t, m)
+		for field, want := range map[string]float64{"temperature": 10, "humidity": 60, "wind_speed": 0, "rain_daily": 2.54} {
+			if state[field] != want {
+				t.Errorf("state %s = %v, want %v", field, state[field], want)
+			}
+		}
+		if _, err := time.Parse(time.RFC3339, state["time"].(string)); err != nil {
+			t.Errorf("state time %v: %v", state["time"], err)
+		}
+
+		gateway := decode(t, b.wait(t, "weather/ecowitt/gateway", false))
+		if gateway["temperature"] != 20.0 || gateway["pressure_relative"] != 1013.24 {
+			t.Errorf("gateway state %v", gateway)
+		}
+	})
+
+	t.Run("values", func(t *testing.T) {
+		for topic, want := range map[string]string{
+			"weather/ecowitt/outdoor/temperature": "10",
+			"weather/ecowitt/outdoor/humidity":    "60",
+			"weather/ecowitt/outdoor/rain_daily":  "2.54",
+			"weather/ecowitt/gateway/humidity":    "45",
+		} {
+			m, ok := b.retained[topic]
+			if !ok || m.payload != want || !m.retain {
+				t.Errorf("%s = %+v, want %s retained", topic, m, want)
+			}
+		}
+	})
+
+	t.Run("discovery", func(t *testing.T) {
+		m, ok := b.retained["homeassistant/sensor/weather_ecowitt_outdoor/temperature/config"]
+		if !ok || !m.retain {
+			t.Fatalf("temperature discovery %+v", m)
+		}
+		discovery := decode(t, m)
+		for key, want := range map[string]string{
+			"name":                "Temperature",
+			"unique_id":           "weather_ecowitt_outdoor_temperature",
+			"state_topic":         "weather/ecowitt/outdoor",
+			"value_template":      "{{ value_json.temperature }}",
+			"unit_of_measurement": "°C",
+			"device_class":        "temperature",
+			"state_class":         "measurement",
+			"availability_topic":  "weather/status",
+		} {
+			if discovery[key] != want {
+				t.Errorf("discovery %s = %v, want %s", key, discovery[key], want)
+			}
+		}
+		device := discovery["device"].(map[string]interface{})
+		if ids := device["identifiers"].([]interface{}); len(ids) != 1 || ids[0] != "weather_ecowitt_outdoor" || device["manufacturer"] != "Ecowitt" {
+			t.Errorf("discovery device %v", device)
+		}
+
+		rain := decode(t, b.retained["homeassistant/sensor/weather_ecowitt_outdoor/rain_daily/config"])
+		if rain["state_class"] != "total_increasing" || rain["device_class"] != "precipitation" || rain["unit_of_measurement"] != "mm" {
+			t.Errorf("rain discovery %v", rain)
+		}
+	})
+
+	t.Run("subscription", func(t *testing.T) {
+		b.publish(t, "tasmota/tele/kitchen/SENSOR", `{"AM2301":{"Temperature":21.5,"Humidity":40}}`)
+		state := decode(t, b.wait(t, "weather/ecowitt/th2", false))
+		if state["temperature"] != 21.5 {
+			t.Errorf("th2 state %v", state)
+		}
+		if m := b.wait(t, "weather/ecowitt/th2/temperature", false); m.payload != "21.5" || !m.retain {
+			t.Errorf("th2 temperature %+v", m)
+		}
+	})
+}
@@ -0,0 +1,216 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"neverending.dev/weather/airgradient"
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/ecowitt"
+	"neverending.dev/weather/readings"
+	"neverending.dev/weather/sensors"
+)
+
+/*
+ * Publishes the readings of each sensor when its station reports, as a retained JSON state on the
+ * state topic and each value on its own topic. With discovery on, a Home Assistant MQTT discovery
+ * message is published the first time each reading is seen, so every sensor appears as a device
+ * with an entity for each reading.
+ */
+
+// deviceClasses are the Home Assistant device classes of each quantity
+var deviceClasses = map[string]string{
+	"temperature": "temperature",
+	"humidity":    "humidity",
+	"pressure":    "atmospheric_pressure",
+	"wind":        "wind_speed",
+	"direction":   "wind_direction",
+	"solar":       "irradiance",
+	"rain":        "precipitation",
+	"rain_rate":   "precipitation_intensity",
+	"moisture":    "moisture",
+	"co2":         "carbon_dioxide",
+	"pm":          "pm25",
+	"signal":      "signal_strength",
+	"distance":    "distance",
+}
+
+// totals are the readings that only increase until they are reset
+var totals = map[string]bool{
+	"rain_daily": true, "rain_weekly": true, "rain_monthly": true, "rain_yearly": true, "rain_total": true, "strikes": true,
+}
+
+// names of the readings that are not the field name capitalised
+var names = map[string]string{
+	"uv":    "UV",
+	"co2":   "CO2",
+	"pm2_5": "PM2.5",
+	"rssi":  "Signal strength",
+}
+
+var manufacturers = map[string]string{
+	"ecowitt":     "Ecowitt",
+	"airgradient": "AirGradient",
+}
+
+var unsafe = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+var client *Client
+var lock sync.Mutex
+var discovered = map[string]bool{}
+
+// Start connects to the broker, subscribes to the configured topics and publishes the readings
+// after each report
+func Start() {
+	c := config.Config.MQTT
+	if c.Broker == "" {
+		return
+	}
+
+	client = &Client{
+		Broker:    c.Broker,
+		ClientID:  c.ClientID,
+		Username:  c.Username,
+		Password:  c.Password,
+		KeepAlive: time.Minute,
+		OnConnect: connected,
+	}
+	if c.Status != "" {
+		client.WillTopic = c.Status
+		client.WillPayload = []byte("offline")
+	}
+	ingestSubscriptions(client)
+	go client.Run()
+
+	ecowitt.OnReport(func() { Publish("ecowitt") })
+	airgradient.OnReport(func() { Publish("airgradient") })
+}
+
+// connected marks the station online and publishes everything again, as the broker may have
+// lost the retained messages
+func connected(c *Client) {
+	if status := config.Config.MQTT.Status; status != "" {
+		if err := c.Publish(status, []byte("online"), true); err != nil {
+			log.Printf("mqtt: unable to publish %s: %v", status, err)
+		}
+	}
+
+	lock.Lock()
+	discovered = map[string]bool{}
+	lock.Unlock()
+
+	Publish("")
+}
+
+func topic(template string, r readings.Reading) string {
+	return strings.NewReplacer("{station}", r.Station, "{sensor}", r.Sensor, "{field}", r.Field).Replace(template)
+}
+
+func round(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// Publish sends the current readings of a station, or every station when station is empty
+func Publish(station string) {
+	if client == nil || config.Config.MQTT.StateTopic == "" {
+		return
+	}
+	c := config.Config.MQTT
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	states := map[string]map[string]interface{}{}
+	order := []string{}
+	for _, r := range readings.Current() {
+		if station != "" && r.Station != station {
+			continue
+		}
+
+		stateTopic := topic(c.StateTopic, r)
+		if _, ok := states[stateTopic]; !ok {
+			states[stateTopic] = map[string]interface{}{"time": r.Time.UTC().Format(time.RFC3339)}
+			order = append(order, stateTopic)
+		}
+		states[stateTopic][r.Field] = round(r.Value)
+
+		if c.ValueTopic != "" {
+			value, _ := json.Marshal(round(r.Value))
+			if err := client.Publish(topic(c.ValueTopic, r), value, true); err != nil {
+				log.Printf("mqtt: unable to publish %s: %v", topic(c.ValueTopic, r), err)
+				return
+			}
+		}
+
+		if c.Discovery && !discovered[r.Station+"/"+r.Sensor+"/"+r.Field] {
+			if err := discover(r, stateTopic); err != nil {
+				log.Printf("mqtt: unable to publish discovery for %s %s %s: %v", r.Station, r.Sensor, r.Field, err)
+				return
+			}
+			discovered[r.Station+"/"+r.Sensor+"/"+r.Field] = true
+		}
+	}
+
+	for _, stateTopic := range order {
+		data, _ := json.Marshal(states[stateTopic])
+		if err := client.Publish(stateTopic, data, true); err != nil {
+			log.Printf("mqtt: unable to publish %s: %v", stateTopic, err)
+			return
+		}
+	}
+}
+
+// discover publishes the Home Assistant discovery message for a reading
+func discover(r readings.Reading, stateTopic string) error {
+	c := config.Config.MQTT
+	device := unsafe.ReplaceAllString("weather_"+r.Station+"_"+r.Sensor, "_")
+
+	name, ok := names[r.Field]
+	if !ok {
+		name = strings.ReplaceAll(r.Field, "_", " ")
+		name = strings.ToUpper(name[:1]) + name[1:]
+	}
+	message := map[string]interface{}{
+		"name":           name,
+		"unique_id":      device + "_" + r.Field,
+		"object_id":      device + "_" + r.Field,
+		"state_topic":    stateTopic,
+		"value_template": "{{ value_json." + r.Field + " }}",
+	}
+	if r.Unit != "" {
+		message["unit_of_measurement"] = r.Unit
+	}
+	if class, ok := deviceClasses[r.Quantity]; ok {
+		message["device_class"] = class
+	}
+	switch {
+	case totals[r.Field]:
+		message["state_class"] = "total_increasing"
+	case r.Quantity != "direction":
+		message["state_class"] = "measurement"
+	}
+	if c.Status != "" {
+		message["availability_topic"] = c.Status
+	}
+
+	info := map[string]interface{}{
+		"identifiers":  []string{device},
+		"name":         sensors.Name(r.Station, r.Sensor),
+		"manufacturer": manufacturers[r.Station],
+	}
+	if s, ok := sensors.Lookup(r.Station, r.Sensor); ok && s.Zone != "" {
+		info["suggested_area"] = s.Zone
+	}
+	message["device"] = info
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return client.Publish(c.DiscoveryPrefix+"/sensor/"+device+"/"+r.Field+"/config", data, true)
+}
@@ -0,0 +1,341 @@
+package noaa
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/history"
+	"neverending.dev/weather/measurement/Rainfall"
+	"neverending.dev/weather/measurement/Temperature"
+	"neverending.dev/weather/measurement/Velocity"
+)
+
+/*
+ * NOAA style climatological summaries generated from the daily history. The monthly report has a
+ * row per day, the yearly report a row per month. Values are presented in the configured units.
+ */
+
+var compass = []string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"}
+
+// Row is a single line of a report, a day in the monthly report or a month in the yearly report
+type Row struct {
+	Label     string
+	Samples   int
+	MeanTemp  float64
+	High      float64
+	HighTime  time.Time
+	Low       float64
+	LowTime   time.Time
+	HeatDays  float64
+	CoolDays  float64
+	Rain      float64
+	AvgWind   float64
+	HighGust  float64
+	GustTime  time.Time
+	Direction string
+
+	direction [history.Sectors]uint64
+}
+
+// Report is a complete monthly or yearly summary
+type Report struct {
+	Title   string
+	Yearly  bool
+	Rows    []Row
+	Summary Row
+}
+
+// degreeDays returns the heating and cooling degree days using the configured bases, NOAA
+// uses 65 °F for both
+func degreeDays(mean Temperature.Temperature, unit Temperature.Unit) (float64, float64) {
+	heating, cooling := 0.0, 0.0
+	if base := config.Config.DegreeDays.Heating().Get(unit); mean.Get(unit) < base {
+		heating = base - mean.Get(unit)
+	}
+	if base := config.Config.DegreeDays.Cooling().Get(unit); mean.Get(unit) > base {
+		cooling = mean.Get(unit) - base
+	}
+	return heating, cooling
+}
+
+// direction returns the compass point the wind blew from most often, dashes if it never blew
+func direction(sectors [history.Sectors]uint64) string {
+	degrees, ok := history.Dominant(sectors)
+	if !ok {
+		return "---"
+	}
+	return compass[int(degrees)*len(compass)/360]
+}
+
+func dayRow(day history.Day) Row {
+	units := config.Config.Units
+	tu := units.TemperatureUnit()
+	wu := units.WindUnit()
+
+	row := Row{
+		Label:     day.Date[8:],
+		Samples:   day.Samples,
+		MeanTemp:  day.MeanTemperature().Get(tu),
+		High:      Temperature.New(day.HighTemperature.Value, Temperature.Celsius).Get(tu),
+		HighTime:  day.HighTemperature.Time,
+		Low:       Temperature.New(day.LowTemperature.Value, Temperature.Celsius).Get(tu),
+		LowTime:   day.LowTemperature.Time,
+		Rain:      Rainfall.New(day.Rain, Rainfall.Millimetre).Get(units.RainUnit()),
+		AvgWind:   day.MeanWindSpeed().Get(wu),
+		HighGust:  Velocity.New(day.HighGust.Value, Velocity.KilometresPerHour).Get(wu),
+		GustTime:  day.HighGust.Time,
+		direction: day.Direction,
+	}
+	row.HeatDays, row.CoolDays = degreeDays(day.MeanTemperature(), tu)
+	row.Direction = direction(row.direction)
+
+	return row
+}
+
+// summarise combines rows into a single row. Means are weighted by the number of samples while
+// degree days and rain are totalled.
+func summarise(label string, rows []Row) Row {
+	summary := Row{Label: label}
+	tempSum := 0.0
+	windSum := 0.0
+
+	for _, row := range rows {
+		if row.Samples == 0 {
+			continue
+		}
+		if summary.Samples == 0 || row.High > summary.High {
+			summary.High = row.High
+			summary.HighTime = row.HighTime
+		}
+		if summary.Samples == 0 || row.Low < summary.Low {
+			summary.Low = row.Low
+			summary.LowTime = row.LowTime
+		}
+		if summary.Samples == 0 || row.HighGust > summary.HighGust {
+			summary.HighGust = row.HighGust
+			summary.GustTime = row.GustTime
+		}
+		summary.Samples += row.Samples
+		tempSum += row.MeanTemp * float64(row.Samples)
+		windSum += row.AvgWind * float64(row.Samples)
+		summary.HeatDays += row.HeatDays
+		summary.CoolDays += row.CoolDays
+		summary.Rain += row.Rain
+		for i, count := range row.direction {
+			summary.direction[i] += count
+		}
+	}
+
+	if summary.Samples > 0 {
+		summary.MeanTemp = tempSum / float64(summary.Samples)
+		summary.AvgWind = windSum / float64(summary.Samples)
+	}
+	summary.Direction = direction(summary.direction)
+
+	return summary
+}
+
+// Monthly generates the summary for a month with a row per day
+func Monthly(year int, month time.Month) (Report, error) {
+	start := time.Date(year, month, 1, 0, 0, 0, 0, config.Location())
+	report := Report{
+		Title: fmt.Sprintf("MONTHLY CLIMATOLOGICAL SUMMARY for %s", start.Format("Jan. 2006")),
+	}
+
+	m, err := history.Load(start.Format("2006-01"))
+	if err != nil {
+		return report, err
+	}
+
+	for _, day := range m.Days {
+		if day.Samples > 0 {
+			report.Rows = append(report.Rows, dayRow(day))
+		}
+	}
+	report.Summary = summarise("", report.Rows)
+
+	return report, nil
+}
+
+// Yearly generates the summary for a year with a row per month
+func Yearly(year int) (Report, error) {
+	report := Report{
+		Title:  fmt.Sprintf("ANNUAL CLIMATOLOGICAL SUMMARY for %d", year),
+		Yearly: true,
+	}
+
+	for month := time.January; month <= time.December; month++ {
+		monthly, err := Monthly(year, month)
+		if err != nil {
+			return report, err
+		}
+		if len(monthly.Rows) > 0 {
+			report.Rows = append(report.Rows, summarise(month.String()[:3], monthly.Rows))
+		}
+	}
+	report.Summary = summarise("", report.Rows)
+
+	return report, nil
+}
+
+// when formats the time of an extreme, the time of day for a daily row and the date otherwise
+func (r Report) when(t time.Time, summary bool) string {
+	t = t.In(config.Location())
+	switch {
+	case !summary && !r.Yearly:
+		return t.Format("15:04")
+	case r.Yearly && summary:
+		return t.Format("Jan 02")
+	}
+	return t.Format("02")
+}
+
+// Text writes the report in the traditional fixed width layout
+func (r Report) Text(w io.Writer) {
+	units := config.Config.Units
+	station := config.Config.Station
+	label := "DAY"
+	if r.Yearly {
+		label = "MON"
+	}
+
+	fmt.Fprintf(w, "%s\n\n", r.Title)
+	fmt.Fprintf(w, "NAME: %s\n", station.Name)
+	fmt.Fprintf(w, "ELEV: %.0f m  LAT: %.3f  LONG: %.3f\n\n", station.Elevation, station.Latitude, station.Longitude)
+	fmt.Fprintf(w, "TEMPERATURE (%s), RAIN (%s), WIND SPEED (%s)\n\n",
+		units.TemperatureUnit(), units.RainUnit(), units.WindUnit())
+	fmt.Fprintf(w, "%-6s %6s %6s %6s %6s %6s %6s %6s %7s %6s %6s %6s %4s\n",
+		"", "MEAN", "", "", "", "", "HEAT", "COOL", "", "AVG", "", "", "DOM")
+	fmt.Fprintf(w, "%-6s %6s %6s %6s %6s %6s %6s %6s %7s %6s %6s %6s %4s\n",
+		label, "TEMP", "HIGH", "TIME", "LOW", "TIME", "DEG", "DEG", "RAIN", "WIND", "HIGH", "TIME", "DIR")
+	fmt.Fprintln(w, strings.Repeat("-", 88))
+
+	for _, row := range r.Rows {
+		r.textRow(w, row, false)
+	}
+
+	fmt.Fprintln(w, strings.Repeat("-", 88))
+	if r.Summary.Samples > 0 {
+		r.textRow(w, r.Summary, true)
+	}
+}
+
+func (r Report) textRow(w io.Writer, row Row, summary bool) {
+	fmt.Fprintf(w, "%-6s %6.1f %6.1f %6s %6.1f %6s %6.1f %6.1f %7.1f %6.1f %6.1f %6s %4s\n",
+		row.Label, row.MeanTemp,
+		row.High, r.when(row.HighTime, summary || r.Yearly),
+		row.Low, r.when(row.LowTime, summary || r.Yearly),
+		row.HeatDays, row.CoolDays, row.Rain, row.AvgWind,
+		row.HighGust, r.when(row.GustTime, summary || r.Yearly),
+		row.Direction)
+}
+
+// CSV writes the report rows with a header, the summary is the final row labelled "total"
+func (r Report) CSV(w io.Writer) error {
+	units := config.Config.Units
+	out := csv.NewWriter(w)
+
+	out.Write([]string{
+		"period",
+		fmt.Sprintf("mean_temperature_%s", units.TemperatureUnit()),
+		fmt.Sprintf("high_temperature_%s", units.TemperatureUnit()), "high_temperature_time",
+		fmt.Sprintf("low_temperature_%s", units.TemperatureUnit()), "low_temperature_time",
+		"heating_degree_days", "cooling_degree_days",
+		fmt.Sprintf("rain_%s", units.RainUnit()),
+		fmt.Sprintf("average_wind_%s", units.WindUnit()),
+		fmt.Sprintf("high_gust_%s", units.WindUnit()), "high_gust_time",
+		"dominant_direction",
+	})
+
+	rows := r.Rows
+	if r.Summary.Samples > 0 {
+		summary := r.Summary
+		summary.Label = "total"
+		rows = append(rows, summary)
+	}
+
+	for _, row := range rows {
+		out.Write([]string{
+			row.Label,
+			fmt.Sprintf("%.1f", row.MeanTemp),
+			fmt.Sprintf("%.1f", row.High), row.HighTime.In(config.Location()).Format(time.RFC3339),
+			fmt.Sprintf("%.1f", row.Low), row.LowTime.In(config.Location()).Format(time.RFC3339),
+			fmt.Sprintf("%.1f", row.HeatDays), fmt.Sprintf("%.1f", row.CoolDays),
+			fmt.Sprintf("%.1f", row.Rain),
+			fmt.Sprintf("%.1f", row.AvgWind),
+			fmt.Sprintf("%.1f", row.HighGust), row.GustTime.In(config.Location()).Format(time.RFC3339),
+			row.Direction,
+		})
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// generate parses the period, either a month (YYYY-MM) or a year (YYYY), and generates the report
+func generate(month string, year string) (Report, error) {
+	if month != "" {
+		t, err := time.Parse("2006-01", month)
+		if err != nil {
+			return Report{}, fmt.Errorf("invalid month %q, expected YYYY-MM", month)
+		}
+		return Monthly(t.Year(), t.Month())
+	}
+
+	if year != "" {
+		t, err := time.Parse("2006", year)
+		if err != nil {
+			return Report{}, fmt.Errorf("invalid year %q, expected YYYY", year)
+		}
+		return Yearly(t.Year())
+	}
+
+	return Report{}, fmt.Errorf("a month or year is required")
+}
+
+// Handler serves a report for ?month=YYYY-MM or ?year=YYYY, as plain text or with ?format=csv
+func Handler(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	report, err := generate(query.Get("month"), query.Get("year"))
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if query.Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		report.CSV(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	report.Text(w)
+}
+
+// Command implements the noaa subcommand, writing a report to stdout
+func Command(args []string) error {
+	flags := flag.NewFlagSet("noaa", flag.ExitOnError)
+	month := flags.String("month", "", "month to summarise, YYYY-MM")
+	year := flags.String("year", "", "year to summarise, YYYY")
+	format := flags.String("format", "text", "output format, text or csv")
+	flags.Parse(args)
+
+	report, err := generate(*month, *year)
+	if err != nil {
+		return err
+	}
+
+	if *format == "csv" {
+		return report.CSV(os.Stdout)
+	}
+	report.Text(os.Stdout)
+	return nil
+}
@@ -0,0 +1,125 @@
+package otlp
+
+import (
+	"sort"
+	"strings"
+
+	"neverending.dev/weather/measurement/Pressure"
+	"neverending.dev/weather/measurement/Rainfall"
+	"neverending.dev/weather/measurement/Temperature"
+	"neverending.dev/weather/measurement/Velocity"
+	"neverending.dev/weather/protobuf"
+	"neverending.dev/weather/readings"
+)
+
+/*
+ * The OTLP export request, hand encoded for the messages used. Every reading is a gauge, the rain
+ * totals reset on the gateway's schedule rather than the exporter's so are not sent as sums.
+ *
+ *   message ExportMetricsServiceRequest { repeated ResourceMetrics resource_metrics = 1; }
+ *   message ResourceMetrics { Resource resource = 1; repeated ScopeMetrics scope_metrics = 2; }
+ *   message Resource        { repeated KeyValue attributes = 1; }
+ *   message ScopeMetrics    { InstrumentationScope scope = 1; repeated Metric metrics = 2; }
+ *   message Metric          { string name = 1; string unit = 3; Gauge gauge = 5; }
+ *   message Gauge           { repeated NumberDataPoint data_points = 1; }
+ *   message NumberDataPoint { repeated KeyValue attributes = 7; fixed64 time_unix_nano = 3; double as_double = 4; }
+ *   message KeyValue        { string key = 1; AnyValue value = 2; }
+ *   message AnyValue        { string string_value = 1; }
+ *
+ * https://github.com/open-telemetry/opentelemetry-proto
+ */
+
+// scope is the instrumentation scope the metrics are reported under
+const scope = "neverending.dev/weather"
+
+// units are the UCUM units of the readings not covered by the measurement packages
+var units = map[string]string{
+	"%":     "%",
+	"°":     "deg",
+	"W/m²":  "W/m2",
+	"ppm":   "[ppm]",
+	"µg/m³": "ug/m3",
+	"km":    "km",
+	"dBm":   "dBm",
+	"":      "1",
+}
+
+// unit returns the UCUM unit of a reading
+func unit(r readings.Reading) string {
+	switch r.Quantity {
+	case "temperature":
+		u, _ := Temperature.Parse(r.Unit)
+		return u.UCUM()
+	case "pressure":
+		u, _ := Pressure.Parse(r.Unit)
+		return u.UCUM()
+	case "wind":
+		u, _ := Velocity.Parse(r.Unit)
+		return u.UCUM()
+	case "rain":
+		u, _ := Rainfall.Parse(r.Unit)
+		return u.UCUM()
+	case "rain_rate":
+		u, _ := Rainfall.Parse(strings.TrimSuffix(r.Unit, "/h"))
+		return u.UCUM() + "/h"
+	}
+	return units[r.Unit]
+}
+
+func appendAttribute(b []byte, field int, key string, value string) []byte {
+	v := protobuf.AppendString(nil, 1, value)
+	kv := protobuf.AppendString(nil, 1, key)
+	kv = protobuf.AppendBytes(kv, 2, v)
+	return protobuf.AppendBytes(b, field, kv)
+}
+
+func appendAttributes(b []byte, field int, attributes map[string]string) []byte {
+	keys := []string{}
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		b = appendAttribute(b, field, key, attributes[key])
+	}
+	return b
+}
+
+// point is a reading with the attributes of its sensor
+type point struct {
+	reading    readings.Reading
+	attributes map[string]string
+}
+
+// encode returns the export request for the readings of one station, a metric for each field
+// with a data point for each sensor
+func encode(resource map[string]string, points []point) []byte {
+	grouped := map[string][]point{}
+	order := []string{}
+	for _, p := range points {
+		if _, ok := grouped[p.reading.Field]; !ok {
+			order = append(order, p.reading.Field)
+		}
+		grouped[p.reading.Field] = append(grouped[p.reading.Field], p)
+	}
+
+	metrics := protobuf.AppendBytes(nil, 1, protobuf.AppendString(nil, 1, scope))
+	for _, field := range order {
+		gauge := []byte{}
+		for _, p := range grouped[field] {
+			dp := appendAttributes(nil, 7, p.attributes)
+			dp = protobuf.AppendFixed64(dp, 3, uint64(p.reading.Time.UnixNano()))
+			dp = protobuf.AppendDouble(dp, 4, p.reading.Value)
+			gauge = protobuf.AppendBytes(gauge, 1, dp)
+		}
+
+		metric := protobuf.AppendString(nil, 1, "weather."+field)
+		metric = protobuf.AppendString(metric, 3, unit(grouped[field][0].reading))
+		metric = protobuf.AppendBytes(metric, 5, gauge)
+		metrics = protobuf.AppendBytes(metrics, 2, metric)
+	}
+
+	rm := protobuf.AppendBytes(nil, 1, appendAttributes(nil, 1, resource))
+	rm = protobuf.AppendBytes(rm, 2, metrics)
+	return protobuf.AppendBytes(nil, 1, rm)
+}
@@ -0,0 +1,184 @@
+package otlp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"neverending.dev/weather/airgradient"
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/ecowitt"
+	"neverending.dev/weather/readings"
+	"neverending.dev/weather/sensors"
+)
+
+/*
+ * Exports each reading as an OpenTelemetry gauge to a collector using OTLP over HTTP with
+ * protobuf, as each report is received. Each station is a resource, identified by its ID and
+ * model, and each sensor is a data point attribute. Requests that fail because the collector is
+ * unavailable are retried with a backoff, keeping the most recent requests.
+ *
+ * OpenTelemetry Protocol Specification 1.0, OTLP/HTTP
+ */
+
+const (
+	// maxPending is the number of requests kept while the collector is unavailable
+	maxPending = 1000
+
+	maxBackoff = 2 * time.Minute
+)
+
+var pending = [][]byte{}
+var lock sync.Mutex
+var wake = make(chan struct{}, 1)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// retryable is a request the collector could not accept now but may later
+type retryable struct {
+	error
+}
+
+// Start exports the readings after each report
+func Start() {
+	if config.Config.OTLP.Endpoint == "" {
+		return
+	}
+
+	readings.OnReport(export)
+	go run()
+}
+
+// Resource returns the attributes identifying a station
+func Resource(station string) map[string]string {
+	attributes := map[string]string{
+		"service.name": "weather",
+	}
+	for key, value := range config.Config.OTLP.Attributes {
+		attributes[key] = value
+	}
+
+	attributes["weather.station"] = station
+	switch station {
+	case "ecowitt":
+		gateway := ecowitt.Snapshot().Gateway
+		attributes["weather.station.id"] = config.Config.Station.ID
+		attributes["weather.station.model"] = gateway.Model
+		attributes["weather.station.type"] = gateway.StationType
+	case "airgradient":
+		attributes["weather.station.id"] = airgradient.Snapshot().ID
+		attributes["weather.station.model"] = "AirGradient"
+	}
+	for key, value := range attributes {
+		if value == "" {
+			delete(attributes, key)
+		}
+	}
+	return attributes
+}
+
+// export queues a request with the readings of a report
+func export(received []readings.Reading) {
+	if len(received) == 0 {
+		return
+	}
+
+	points := []point{}
+	for _, r := range received {
+		attributes := sensors.Tags(r.Station, r.Sensor)
+		attributes["sensor"] = r.Sensor
+		points = append(points, point{reading: r, attributes: attributes})
+	}
+	request := encode(Resource(received[0].Station), points)
+
+	lock.Lock()
+	pending = append(pending, request)
+	if dropped := len(pending) - maxPending; dropped > 0 {
+		log.Printf("otlp: collector unavailable, dropping %d requests", dropped)
+		pending = pending[dropped:]
+	}
+	lock.Unlock()
+
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// run sends the pending requests in order, backing off while the collector is unavailable
+func run() {
+	backoff := time.Second
+	for range wake {
+		for {
+			lock.Lock()
+			if len(pending) == 0 {
+				lock.Unlock()
+				break
+			}
+			request := pending[0]
+			pending = pending[1:]
+			lock.Unlock()
+
+			err := send(request)
+			if _, ok := err.(retryable); ok {
+				log.Printf("otlp: unable to export, retrying in %s: %v", backoff, err)
+				lock.Lock()
+				if len(pending) < maxPending {
+					pending = append([][]byte{request}, pending...)
+				}
+				lock.Unlock()
+
+				time.Sleep(backoff)
+				if backoff *= 2; backoff > maxBackoff {
+					backoff = maxBackoff
+				}
+				continue
+			}
+			if err != nil {
+				log.Printf("otlp: dropping request: %v", err)
+			}
+			backoff = time.Second
+		}
+	}
+}
+
+// endpoint returns the metrics URL, the path is added to a collector's base URL
+func endpoint() string {
+	e := config.Config.OTLP.Endpoint
+	if strings.HasSuffix(e, "/v1/metrics") {
+		return e
+	}
+	return strings.TrimSuffix(e, "/") + "/v1/metrics"
+}
+
+func send(request []byte) error {
+	req, err := http.NewRequest("POST", endpoint(), bytes.NewReader(request))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for name, value := range config.Config.OTLP.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return retryable{err}
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusBadGateway,
+		resp.StatusCode == http.StatusServiceUnavailable, resp.StatusCode == http.StatusGatewayTimeout:
+		return retryable{fmt.Errorf("%s", resp.Status)}
+	}
+	return fmt.Errorf("%s", resp.Status)
+}
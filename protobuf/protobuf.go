@@ -0,0 +1,66 @@
+package protobuf
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+/*
+ * Protocol buffer wire format, enough to hand write the few messages sent by the remote write and
+ * OpenTelemetry outputs without generated code. Each function appends a field to a message.
+ *
+ * https://protobuf.dev/programming-guides/encoding/
+ */
+
+// wire types
+const (
+	Varint          = 0
+	Fixed64         = 1
+	LengthDelimited = 2
+)
+
+// AppendVarint appends an unsigned varint without a tag
+func AppendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// AppendTag appends the field number and wire type that start a field
+func AppendTag(b []byte, field int, wireType int) []byte {
+	return AppendVarint(b, uint64(field<<3|wireType))
+}
+
+// AppendBytes appends a bytes field or an embedded message
+func AppendBytes(b []byte, field int, data []byte) []byte {
+	b = AppendTag(b, field, LengthDelimited)
+	b = AppendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// AppendString appends a string field
+func AppendString(b []byte, field int, s string) []byte {
+	return AppendBytes(b, field, []byte(s))
+}
+
+// AppendInt appends an int64, uint64 or bool field
+func AppendInt(b []byte, field int, v uint64) []byte {
+	b = AppendTag(b, field, Varint)
+	return AppendVarint(b, v)
+}
+
+// AppendFixed64 appends a fixed64 or sfixed64 field
+func AppendFixed64(b []byte, field int, v uint64) []byte {
+	value := make([]byte, 8)
+	binary.LittleEndian.PutUint64(value, v)
+
+	b = AppendTag(b, field, Fixed64)
+	return append(b, value...)
+}
+
+// AppendDouble appends a double field
+func AppendDouble(b []byte, field int, v float64) []byte {
+	return AppendFixed64(b, field, math.Float64bits(v))
+}
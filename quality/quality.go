@@ -0,0 +1,231 @@
+package quality
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/metrics"
+)
+
+/*
+ * Data quality checks applied to readings as they are parsed, after calibration and before they
+ * are stored. Each reading passes through the registered filters in turn, the first filter to
+ * find it suspect gives the reason. Suspect readings are dropped, or kept and marked suspect when
+ * the configured action is mark.
+ */
+
+// Reading is a single value from a sensor in the units listed in config.Quality
+type Reading struct {
+	Station  string
+	Sensor   string
+	Field    string // the station's name for the reading, sensors may report a quantity more than once
+	Quantity string
+	Value    float64
+	Time     time.Time
+}
+
+func (r Reading) key() string {
+	return r.Station + "/" + r.Sensor + "/" + r.Field
+}
+
+// Filter checks readings, filters may keep their own history of each sensor's readings
+type Filter interface {
+	// Check returns the reason the reading is suspect, or "" if it passes
+	Check(r Reading) string
+}
+
+type rejection struct {
+	station  string
+	sensor   string
+	quantity string
+	reason   string
+}
+
+var lock sync.Mutex
+var filters = []Filter{}
+var rejected = map[rejection]uint64{}
+var suspect = map[source]bool{}
+
+// source identifies a single reading from a station
+type source struct {
+	station string
+	sensor  string
+	field   string
+}
+
+// Register adds a filter to the end of the checks
+func Register(f Filter) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	filters = append(filters, f)
+}
+
+// Start registers the built in filters from the configuration
+func Start() {
+	q := config.Config.Quality
+
+	Register(&Range{Limits: q.Ranges})
+	Register(&RateOfChange{Limits: q.RateLimits, last: map[string]Reading{}})
+	Register(&Hampel{
+		Window:       q.Spike.Window,
+		Threshold:    q.Spike.Threshold,
+		MinDeviation: q.Spike.MinDeviation,
+		history:      map[string][]float64{},
+	})
+}
+
+// Accept checks a reading, returning false if it should not be stored
+func Accept(station string, sensor string, field string, quantity string, value float64) bool {
+	lock.Lock()
+	defer lock.Unlock()
+
+	r := Reading{Station: station, Sensor: sensor, Field: field, Quantity: quantity, Value: value, Time: time.Now()}
+
+	reason := ""
+	for _, f := range filters {
+		if reason = f.Check(r); reason != "" {
+			break
+		}
+	}
+
+	suspect[source{station, sensor, field}] = reason != ""
+	if reason == "" {
+		return true
+	}
+
+	rejected[rejection{station, sensor, quantity, reason}]++
+	return config.Config.Quality.Action == "mark"
+}
+
+// Suspect reports whether the latest reading of a sensor's field failed a check
+func Suspect(station string, sensor string, field string) bool {
+	lock.Lock()
+	defer lock.Unlock()
+
+	return suspect[source{station, sensor, field}]
+}
+
+// Report adds a counter of the readings found suspect for each sensor and reason to the exporter
+// report, and whether each reading is currently suspect when suspect readings are marked
+func Report(report map[string]string) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for r, count := range rejected {
+		keystr := fmt.Sprintf("weather_readings_rejected_total{%s,%s,%s,%s}", metrics.Label("station", r.station),
+			metrics.Label("sensor", r.sensor), metrics.Label("quantity", r.quantity), metrics.Label("reason", r.reason))
+		report[keystr] = fmt.Sprintf("%d", count)
+	}
+
+	if config.Config.Quality.Action != "mark" {
+		return
+	}
+	for s, flagged := range suspect {
+		keystr := fmt.Sprintf("weather_reading_suspect{%s,%s,%s}", metrics.Label("station", s.station),
+			metrics.Label("sensor", s.sensor), metrics.Label("field", s.field))
+		report[keystr] = "0"
+		if flagged {
+			report[keystr] = "1"
+		}
+	}
+}
+
+// Range rejects readings outside the physically possible range of the quantity
+type Range struct {
+	Limits map[string]config.Range
+}
+
+func (f *Range) Check(r Reading) string {
+	limit, ok := f.Limits[r.Quantity]
+	if !ok {
+		return ""
+	}
+	if r.Value < limit.Min || r.Value > limit.Max {
+		return "range"
+	}
+	return ""
+}
+
+// RateOfChange rejects readings that have changed faster than the limit per minute since the last
+// accepted reading. As the limit grows with the time since the last accepted reading, a genuine
+// step change is accepted once enough time has passed.
+type RateOfChange struct {
+	Limits map[string]float64
+
+	last map[string]Reading
+}
+
+func (f *RateOfChange) Check(r Reading) string {
+	limit, ok := f.Limits[r.Quantity]
+	if !ok {
+		return ""
+	}
+
+	last, ok := f.last[r.key()]
+	if ok {
+		minutes := math.Max(1, r.Time.Sub(last.Time).Minutes())
+		if math.Abs(r.Value-last.Value) > limit*minutes {
+			return "rate_of_change"
+		}
+	}
+
+	f.last[r.key()] = r
+	return ""
+}
+
+// Hampel rejects spikes, readings too far from the median of the recent readings. Every reading is
+// kept in the window so a sustained change moves the median and stops being rejected.
+type Hampel struct {
+	Window       int
+	Threshold    float64
+	MinDeviation map[string]float64
+
+	history map[string][]float64
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+func (f *Hampel) Check(r Reading) string {
+	minDeviation, ok := f.MinDeviation[r.Quantity]
+	if !ok || f.Window < 3 {
+		return ""
+	}
+
+	window := f.history[r.key()]
+	reason := ""
+
+	if len(window) >= f.Window/2 {
+		m := median(window)
+		deviations := make([]float64, len(window))
+		for i, value := range window {
+			deviations[i] = math.Abs(value - m)
+		}
+		// 1.4826 scales the median absolute deviation to the standard deviation
+		scale := math.Max(f.Threshold*1.4826*median(deviations), minDeviation)
+		if math.Abs(r.Value-m) > scale {
+			reason = "spike"
+		}
+	}
+
+	window = append(window, r.Value)
+	if len(window) > f.Window {
+		window = window[len(window)-f.Window:]
+	}
+	f.history[r.key()] = window
+
+	return reason
+}
@@ -0,0 +1,166 @@
+package readings
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"neverending.dev/weather/airgradient"
+	"neverending.dev/weather/ecowitt"
+	"neverending.dev/weather/measurement/Pressure"
+	"neverending.dev/weather/measurement/Rainfall"
+	"neverending.dev/weather/measurement/Temperature"
+	"neverending.dev/weather/measurement/Velocity"
+)
+
+/*
+ * The current readings of every station as a flat list, for the outputs that publish readings
+ * individually rather than as metrics. Readings are in the same units as the exporter: °C, %,
+ * hPa, km/h, mm, mm/h, W/m2, ppm, µg/m3 and km.
+ */
+
+// Reading is a single current value from a sensor
+type Reading struct {
+	Station  string    `json:"station"`
+	Sensor   string    `json:"sensor"`
+	Field    string    `json:"field"`    // name of the reading, unique for the sensor, e.g. rain_daily
+	Quantity string    `json:"quantity"` // what is measured, e.g. rain
+	Value    float64   `json:"value"`
+	Unit     string    `json:"unit"`
+	Time     time.Time `json:"time"`
+}
+
+type builder struct {
+	readings []Reading
+	station  string
+	sensor   string
+	time     time.Time
+	seen     map[string]time.Time // when each ecowitt sensor last reported
+}
+
+func (b *builder) add(field string, quantity string, value float64, unit string) {
+	if math.IsNaN(value) {
+		return // not reported yet
+	}
+	b.readings = append(b.readings, Reading{
+		Station:  b.station,
+		Sensor:   b.sensor,
+		Field:    field,
+		Quantity: quantity,
+		Value:    value,
+		Unit:     unit,
+		Time:     b.time,
+	})
+}
+
+// ecowitt starts the readings of an ecowitt sensor, false if it has not reported
+func (b *builder) ecowitt(sensor string) bool {
+	t, ok := b.seen[sensor]
+	b.station, b.sensor, b.time = "ecowitt", sensor, t
+	return ok
+}
+
+// OnReport registers f to be called with the readings of the sensors included in each report
+func OnReport(f func(readings []Reading)) {
+	ecowitt.OnReport(func() { f(Received("ecowitt")) })
+	airgradient.OnReport(func() { f(Received("airgradient")) })
+}
+
+// Received returns the readings of a station's sensors that were included in its latest report
+func Received(station string) []Reading {
+	latest := time.Time{}
+	current := []Reading{}
+	for _, r := range Current() {
+		if r.Station != station {
+			continue
+		}
+		current = append(current, r)
+		if r.Time.After(latest) {
+			latest = r.Time
+		}
+	}
+
+	received := []Reading{}
+	for _, r := range current {
+		if r.Time.Equal(latest) {
+			received = append(received, r)
+		}
+	}
+	return received
+}
+
+// Current returns the latest readings of every sensor that has reported
+func Current() []Reading {
+	ws := ecowitt.Snapshot()
+	ag := airgradient.Snapshot()
+	b := &builder{readings: []Reading{}, seen: ws.LastSeen}
+
+	if ws.Status == ecowitt.Ready {
+		gateway := ws.Gateway
+		if b.ecowitt("gateway") {
+			b.add("temperature", "temperature", gateway.Temperature.Get(Temperature.Celsius), "°C")
+			b.add("humidity", "humidity", float64(gateway.Humidity.Get()), "%")
+			b.add("pressure_relative", "pressure", gateway.PressureRelative.Get(Pressure.Hectopascal), "hPa")
+			b.add("pressure_absolute", "pressure", gateway.PressureAbsolute.Get(Pressure.Hectopascal), "hPa")
+		}
+
+		outdoor := ws.Outdoor
+		if b.ecowitt("outdoor") {
+			b.add("temperature", "temperature", outdoor.Temperature.Get(Temperature.Celsius), "°C")
+			b.add("humidity", "humidity", float64(outdoor.Humidity.Get()), "%")
+			b.add("wind_speed", "wind", outdoor.WindSpeed.Get(Velocity.KilometresPerHour), "km/h")
+			b.add("wind_gust", "wind", outdoor.WindGust.Get(Velocity.KilometresPerHour), "km/h")
+			b.add("wind_direction", "direction", float64(outdoor.WindDirection), "°")
+			b.add("solar_radiation", "solar", outdoor.SolarRadiation, "W/m²")
+			b.add("uv", "uv", float64(outdoor.UV), "")
+			b.add("rain_rate", "rain_rate", outdoor.RainRate.Get(Rainfall.Millimetre), "mm/h")
+			b.add("rain_event", "rain", outdoor.RainEvent.Get(Rainfall.Millimetre), "mm")
+			b.add("rain_hourly", "rain", outdoor.RainHourly.Get(Rainfall.Millimetre), "mm")
+			b.add("rain_daily", "rain", outdoor.RainDaily.Get(Rainfall.Millimetre), "mm")
+			b.add("rain_weekly", "rain", outdoor.RainWeekly.Get(Rainfall.Millimetre), "mm")
+			b.add("rain_monthly", "rain", outdoor.RainMonthly.Get(Rainfall.Millimetre), "mm")
+			b.add("rain_yearly", "rain", outdoor.RainYearly.Get(Rainfall.Millimetre), "mm")
+			b.add("rain_total", "rain", outdoor.RainTotal.Get(Rainfall.Millimetre), "mm")
+		}
+
+		for _, sensor := range ws.TemperatureHumidity {
+			if b.ecowitt(fmt.Sprintf("th%d", sensor.ID)) {
+				b.add("temperature", "temperature", sensor.Temperature.Get(Temperature.Celsius), "°C")
+				b.add("humidity", "humidity", float64(sensor.Humidity.Get()), "%")
+			}
+		}
+
+		for _, sensor := range ws.SoilMoisture {
+			if b.ecowitt(fmt.Sprintf("soil%d", sensor.ID)) {
+				b.add("moisture", "moisture", float64(sensor.Moisture.Get()), "%")
+			}
+		}
+
+		for _, sensor := range ws.Leak {
+			if b.ecowitt(fmt.Sprintf("leak%d", sensor.ID)) {
+				leak := 0.0
+				if sensor.Leak {
+					leak = 1
+				}
+				b.add("leak", "leak", leak, "")
+			}
+		}
+
+		lightning := ws.Lightning
+		if b.ecowitt("lightning") {
+			b.add("distance", "distance", float64(lightning.Distance), "km")
+			b.add("strikes", "count", float64(lightning.Count), "")
+		}
+	}
+
+	if ag.Status == airgradient.Ready {
+		b.station, b.sensor, b.time = "airgradient", ag.ID, ag.LastSeen
+		b.add("temperature", "temperature", ag.Temperature.Get(Temperature.Celsius), "°C")
+		b.add("humidity", "humidity", float64(ag.Humidity.Get()), "%")
+		b.add("co2", "co2", float64(ag.CO2), "ppm")
+		b.add("pm2_5", "pm", float64(ag.PM2dot5), "µg/m³")
+		b.add("rssi", "signal", float64(ag.SignalStrength), "dBm")
+	}
+
+	return b.readings
+}
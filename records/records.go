@@ -0,0 +1,225 @@
+package records
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/ecowitt"
+	"neverending.dev/weather/measurement/Pressure"
+	"neverending.dev/weather/measurement/Rainfall"
+	"neverending.dev/weather/measurement/Temperature"
+	"neverending.dev/weather/measurement/Velocity"
+)
+
+// Record holds an extreme value and the time it occurred. Values are stored in metric units
+// (°C, km/h, mm/h, hPa) regardless of the units reported by the station.
+type Record struct {
+	Value     float64   `json:"value"`
+	Time      time.Time `json:"time"`
+	Direction *int64    `json:"direction,omitempty"` // only set for wind gusts
+}
+
+// Valid reports whether the record has been set
+func (r Record) Valid() bool {
+	return !r.Time.IsZero()
+}
+
+// high sets the record when value is higher, values that were not reported (NaN) are skipped
+func (r *Record) high(value float64, t time.Time) bool {
+	if math.IsNaN(value) {
+		return false
+	}
+	if !r.Valid() || value > r.Value {
+		r.Value = value
+		r.Time = t
+		return true
+	}
+	return false
+}
+
+// low sets the record when value is lower, values that were not reported (NaN) are skipped
+func (r *Record) low(value float64, t time.Time) {
+	if math.IsNaN(value) {
+		return
+	}
+	if !r.Valid() || value < r.Value {
+		r.Value = value
+		r.Time = t
+	}
+}
+
+// Extremes holds the records for a single period (day, month, year or all-time)
+type Extremes struct {
+	Start             time.Time `json:"start"`
+	HighTemperature   Record    `json:"high_temperature"`
+	LowTemperature    Record    `json:"low_temperature"`
+	MaxGust           Record    `json:"max_gust"`
+	MaxRainRate       Record    `json:"max_rain_rate"`
+	HighPressure      Record    `json:"high_pressure"`
+	LowPressure       Record    `json:"low_pressure"`
+	MaxUV             Record    `json:"max_uv"`
+	MaxSolarRadiation Record    `json:"max_solar_radiation"`
+}
+
+// update adds the readings of the sensors that have reported
+func (e *Extremes) update(ws ecowitt.WeatherStation, t time.Time) {
+	if _, ok := ws.LastSeen["outdoor"]; ok {
+		temperature := ws.Outdoor.Temperature.Get(Temperature.Celsius)
+		e.HighTemperature.high(temperature, t)
+		e.LowTemperature.low(temperature, t)
+
+		if e.MaxGust.high(ws.Outdoor.WindGust.Get(Velocity.KilometresPerHour), t) {
+			direction := ws.Outdoor.WindDirection
+			e.MaxGust.Direction = &direction
+		}
+
+		e.MaxRainRate.high(ws.Outdoor.RainRate.Get(Rainfall.Millimetre), t)
+
+		e.MaxUV.high(float64(ws.Outdoor.UV), t)
+		e.MaxSolarRadiation.high(ws.Outdoor.SolarRadiation, t)
+	}
+
+	if _, ok := ws.LastSeen["gateway"]; ok {
+		pressure := ws.Gateway.PressureRelative.Get(Pressure.Hectopascal)
+		e.HighPressure.high(pressure, t)
+		e.LowPressure.low(pressure, t)
+	}
+}
+
+// StationRecords holds the extremes for each period tracked
+type StationRecords struct {
+	Daily   Extremes `json:"daily"`
+	Monthly Extremes `json:"monthly"`
+	Yearly  Extremes `json:"yearly"`
+	AllTime Extremes `json:"all_time"`
+}
+
+var Records = StationRecords{}
+
+// lock guards Records, updated from reports while the metrics and API read them
+var lock sync.Mutex
+
+// current returns a copy of the records
+func current() StationRecords {
+	lock.Lock()
+	defer lock.Unlock()
+	return Records
+}
+
+func filename() string {
+	return filepath.Join(config.Config.DataDir, "records.json")
+}
+
+// Start loads any persisted records and begins tracking reports from the ecowitt gateway
+func Start() {
+	if data, err := os.ReadFile(filename()); err == nil {
+		if err := json.Unmarshal(data, &Records); err != nil {
+			log.Printf("records: unable to load %s: %v", filename(), err)
+		}
+	}
+
+	ecowitt.OnReport(Update)
+}
+
+// Update adds the current ecowitt readings to the records, starting new periods when the
+// observation falls on a different day, month or year in the configured timezone.
+func Update() {
+	t := ecowitt.WS.ObservationTime().In(config.Location())
+
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	month := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	year := time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, t.Location())
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !Records.Daily.Start.Equal(day) {
+		Records.Daily = Extremes{Start: day}
+	}
+	if !Records.Monthly.Start.Equal(month) {
+		Records.Monthly = Extremes{Start: month}
+	}
+	if !Records.Yearly.Start.Equal(year) {
+		Records.Yearly = Extremes{Start: year}
+	}
+
+	Records.Daily.update(ecowitt.WS, t)
+	Records.Monthly.update(ecowitt.WS, t)
+	Records.Yearly.update(ecowitt.WS, t)
+	Records.AllTime.update(ecowitt.WS, t)
+
+	if err := save(); err != nil {
+		log.Printf("records: unable to save %s: %v", filename(), err)
+	}
+}
+
+// save writes the records, the lock must be held
+func save() error {
+	data, err := json.Marshal(Records)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(config.Config.DataDir, 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated records file
+	tmp := filename() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename())
+}
+
+// Report adds a gauge for each record that has been set to the exporter report
+func Report(report map[string]string) {
+	s := current()
+	periods := map[string]Extremes{
+		"daily":    s.Daily,
+		"monthly":  s.Monthly,
+		"yearly":   s.Yearly,
+		"all_time": s.AllTime,
+	}
+
+	for period, e := range periods {
+		records := map[string]Record{
+			"temperature_high":    e.HighTemperature,
+			"temperature_low":     e.LowTemperature,
+			"wind_gust_max":       e.MaxGust,
+			"rain_rate_max":       e.MaxRainRate,
+			"pressure_high":       e.HighPressure,
+			"pressure_low":        e.LowPressure,
+			"uv_max":              e.MaxUV,
+			"solar_radiation_max": e.MaxSolarRadiation,
+		}
+
+		for name, r := range records {
+			if !r.Valid() {
+				continue
+			}
+			keystr := fmt.Sprintf("ecowitt_record_%s_%s", period, name)
+			report[keystr] = fmt.Sprintf("%.2f", r.Value)
+			report[keystr+"_time"] = fmt.Sprintf("%d", r.Time.Unix())
+			if r.Direction != nil {
+				report[keystr+"_direction"] = fmt.Sprintf("%d", *r.Direction)
+			}
+		}
+	}
+}
+
+// Handler serves the current records as JSON
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(current()); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
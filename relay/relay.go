@@ -0,0 +1,204 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/ecowitt"
+	"neverending.dev/weather/metrics"
+)
+
+/*
+ * Forwards each report posted by the Ecowitt gateway to other servers accepting the Ecowitt
+ * protocol, so they can be fed without reconfiguring the gateway. Reports are forwarded as they
+ * were posted, with the PASSKEY replaced or left out when configured. Each server has its own
+ * queue so one that is slow or down does not hold up the gateway or the others. Posts are
+ * retried with a backoff while a server is unavailable, keeping the most recent reports.
+ */
+
+const (
+	// maxQueue is the number of reports kept for each server while it is unavailable
+	maxQueue = 100
+
+	maxBackoff = 5 * time.Minute
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// retryable is a post the server could not accept now but may later
+type retryable struct {
+	error
+}
+
+// target is a server reports are relayed to
+type target struct {
+	config.Relay
+	name string // the URL without credentials, for logs and metrics
+
+	lock        sync.Mutex
+	queue       [][]byte
+	wake        chan struct{}
+	successes   int
+	failures    int
+	dropped     int
+	lastSuccess time.Time
+}
+
+var targets = []*target{}
+
+// Start relays each report posted by the gateway
+func Start() {
+	for _, r := range config.Config.Relays {
+		t := &target{Relay: r, name: r.URL, wake: make(chan struct{}, 1)}
+		if u, err := url.Parse(r.URL); err == nil {
+			t.name = u.Redacted()
+		}
+		targets = append(targets, t)
+		go t.run()
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	ecowitt.OnPost(relay)
+}
+
+// rewrite returns the body of a report with the PASSKEY replaced, or left out when passkey is
+// empty. The other fields are kept as posted.
+func rewrite(body []byte, passkey string) []byte {
+	fields := []string{}
+	for _, field := range strings.Split(string(body), "&") {
+		if !strings.HasPrefix(field, "PASSKEY=") {
+			fields = append(fields, field)
+		} else if passkey != "" {
+			fields = append(fields, "PASSKEY="+url.QueryEscape(passkey))
+		}
+	}
+	return []byte(strings.Join(fields, "&"))
+}
+
+// relay queues a report for each server
+func relay(body []byte) {
+	for _, t := range targets {
+		post := body
+		if t.Passkey != "" || t.StripPasskey {
+			post = rewrite(body, t.Passkey)
+		}
+		t.push(post)
+	}
+}
+
+// push queues a report, dropping the oldest when the queue is full
+func (t *target) push(body []byte) {
+	t.lock.Lock()
+	t.queue = append(t.queue, body)
+	if n := len(t.queue) - maxQueue; n > 0 {
+		log.Printf("relay: %s unavailable, dropping %d reports", t.name, n)
+		t.queue = t.queue[n:]
+		t.dropped += n
+	}
+	t.lock.Unlock()
+
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run posts the queued reports in order, backing off while the server is unavailable
+func (t *target) run() {
+	backoff := time.Second
+	for range t.wake {
+		for {
+			t.lock.Lock()
+			if len(t.queue) == 0 {
+				t.lock.Unlock()
+				break
+			}
+			body := t.queue[0]
+			t.queue = t.queue[1:]
+			t.lock.Unlock()
+
+			err := t.post(body)
+			_, retry := err.(retryable)
+
+			t.lock.Lock()
+			switch {
+			case err == nil:
+				t.successes++
+				t.lastSuccess = time.Now()
+			case retry && len(t.queue) < maxQueue:
+				t.failures++
+				t.queue = append([][]byte{body}, t.queue...)
+			default:
+				t.failures++
+				t.dropped++
+			}
+			t.lock.Unlock()
+
+			if retry {
+				log.Printf("relay: unable to post to %s, retrying in %s: %v", t.name, backoff, err)
+				time.Sleep(backoff)
+				if backoff *= 2; backoff > maxBackoff {
+					backoff = maxBackoff
+				}
+				continue
+			}
+			if err != nil {
+				log.Printf("relay: %s rejected the report: %v", t.name, err)
+			}
+			backoff = time.Second
+		}
+	}
+}
+
+func (t *target) post(body []byte) error {
+	req, err := http.NewRequest("POST", t.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if e, ok := err.(*url.Error); ok {
+			err = e.Err
+		}
+		return retryable{err}
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return retryable{fmt.Errorf("%s", resp.Status)}
+	}
+	return fmt.Errorf("%s", resp.Status)
+}
+
+// Report adds the number of posts to each server that succeeded and failed, the reports dropped,
+// the reports waiting to be sent and the time of the last success
+func Report(report map[string]string) {
+	for _, t := range targets {
+		t.lock.Lock()
+		labels := metrics.Label("target", t.name)
+		report[fmt.Sprintf("weather_relay_posts_total{%s,result=\"success\"}", labels)] = fmt.Sprintf("%d", t.successes)
+		report[fmt.Sprintf("weather_relay_posts_total{%s,result=\"failure\"}", labels)] = fmt.Sprintf("%d", t.failures)
+		report[fmt.Sprintf("weather_relay_dropped_total{%s}", labels)] = fmt.Sprintf("%d", t.dropped)
+		report[fmt.Sprintf("weather_relay_queue_length{%s}", labels)] = fmt.Sprintf("%d", len(t.queue))
+		if !t.lastSuccess.IsZero() {
+			report[fmt.Sprintf("weather_relay_last_success_timestamp_seconds{%s}", labels)] = fmt.Sprintf("%d", t.lastSuccess.Unix())
+		}
+		t.lock.Unlock()
+	}
+}
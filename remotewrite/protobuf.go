@@ -0,0 +1,79 @@
+package remotewrite
+
+import (
+	"sort"
+	"strings"
+
+	"neverending.dev/weather/protobuf"
+)
+
+/*
+ * The remote write request, hand encoded for the few messages used:
+ *
+ *   message WriteRequest { repeated TimeSeries timeseries = 1; }
+ *   message TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+ *   message Label        { string name = 1; string value = 2; }
+ *   message Sample       { double value = 1; int64 timestamp = 2; }
+ */
+
+func appendLabel(b []byte, name string, value string) []byte {
+	label := protobuf.AppendString(nil, 1, name)
+	label = protobuf.AppendString(label, 2, value)
+	return protobuf.AppendBytes(b, 1, label)
+}
+
+func appendSample(b []byte, s Sample) []byte {
+	sample := protobuf.AppendDouble(nil, 1, s.Value)
+	sample = protobuf.AppendInt(sample, 2, uint64(s.Timestamp))
+	return protobuf.AppendBytes(b, 2, sample)
+}
+
+// names returns the label names sorted, as remote write requires
+func names(labels map[string]string) []string {
+	sorted := []string{}
+	for name := range labels {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// series returns a key identifying the series of a sample
+func series(labels map[string]string) string {
+	var b strings.Builder
+	for _, name := range names(labels) {
+		b.WriteString(name)
+		b.WriteByte(0)
+		b.WriteString(labels[name])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// encode returns the write request for the samples, grouped into series with the samples of each
+// series in the order given
+func encode(samples []Sample) []byte {
+	grouped := map[string][]Sample{}
+	order := []string{}
+	for _, s := range samples {
+		key := series(s.Labels)
+		if _, ok := grouped[key]; !ok {
+			order = append(order, key)
+		}
+		grouped[key] = append(grouped[key], s)
+	}
+
+	request := []byte{}
+	for _, key := range order {
+		ts := []byte{}
+		labels := grouped[key][0].Labels
+		for _, name := range names(labels) {
+			ts = appendLabel(ts, name, labels[name])
+		}
+		for _, s := range grouped[key] {
+			ts = appendSample(ts, s)
+		}
+		request = protobuf.AppendBytes(request, 1, ts)
+	}
+	return request
+}
@@ -0,0 +1,293 @@
+package remotewrite
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/readings"
+	"neverending.dev/weather/sensors"
+)
+
+/*
+ * Pushes each reading to a Prometheus remote write endpoint as it is received, timestamped with
+ * the time the report was received rather than when it was scraped. Each reading is a series named
+ * weather_<field>, e.g. weather_temperature{sensor="outdoor",station="ecowitt"}, in the same units
+ * as the exporter.
+ *
+ * Samples are appended to a write ahead log in the data directory before they are queued, so none
+ * are lost if the exporter restarts before they are sent. The log is rewritten with the samples
+ * still queued after each flush, and replayed on start. While the endpoint is unavailable samples
+ * are kept, up to the buffer size, and sent once it is back.
+ */
+
+// Sample is a single value of a series
+type Sample struct {
+	Labels    map[string]string `json:"labels"`
+	Value     float64           `json:"value"`
+	Timestamp int64             `json:"timestamp"` // milliseconds since the epoch
+}
+
+var queue = []Sample{}
+var wal *os.File
+var lock sync.Mutex
+var wake = make(chan struct{}, 1)
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// permanentError is a request the endpoint rejected, which would be rejected again if retried
+type permanentError struct {
+	error
+}
+
+func filename() string {
+	return filepath.Join(config.Config.DataDir, "remote_write.wal")
+}
+
+// Start replays the write ahead log and pushes the readings after each report
+func Start() {
+	if config.Config.RemoteWrite.URL == "" {
+		return
+	}
+
+	if f, err := os.Open(filename()); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			s := Sample{}
+			if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+				continue // a partly written sample
+			}
+			queue = append(queue, s)
+		}
+		f.Close()
+	}
+
+	lock.Lock()
+	if err := rewrite(); err != nil {
+		log.Printf("remote_write: unable to save %s: %v", filename(), err)
+	}
+	lock.Unlock()
+
+	readings.OnReport(add)
+	go run()
+}
+
+// Samples returns a sample for each reading, labelled with the station, sensor and the sensor's
+// configured description
+func Samples(received []readings.Reading) []Sample {
+	samples := []Sample{}
+	for _, r := range received {
+		labels := sensors.Tags(r.Station, r.Sensor)
+		for name, value := range config.Config.RemoteWrite.Labels {
+			labels[name] = value
+		}
+		labels["__name__"] = "weather_" + r.Field
+		labels["station"] = r.Station
+		labels["sensor"] = r.Sensor
+
+		samples = append(samples, Sample{
+			Labels:    labels,
+			Value:     r.Value,
+			Timestamp: r.Time.UnixNano() / int64(time.Millisecond),
+		})
+	}
+	return samples
+}
+
+// add logs and queues the samples of a report, dropping the oldest once the buffer is full
+func add(received []readings.Reading) {
+	c := config.Config.RemoteWrite
+	samples := Samples(received)
+	if len(samples) == 0 {
+		return
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	queue = append(queue, samples...)
+	if dropped := len(queue) - c.BufferSize; dropped > 0 {
+		log.Printf("remote_write: buffer full, dropping %d samples", dropped)
+		queue = queue[dropped:]
+		if err := rewrite(); err != nil {
+			log.Printf("remote_write: unable to save %s: %v", filename(), err)
+		}
+	} else if err := appendLog(samples); err != nil {
+		log.Printf("remote_write: unable to save %s: %v", filename(), err)
+	}
+
+	if len(queue) >= c.BatchSize {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run sends the queued samples each flush interval, or sooner once a batch is full
+func run() {
+	ticker := time.NewTicker(config.Config.RemoteWrite.Interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-wake:
+		}
+		flush()
+	}
+}
+
+// flush sends batches until the queue is empty or a request fails, then rewrites the log with the
+// samples still queued
+func flush() {
+	c := config.Config.RemoteWrite
+	sent := false
+	for {
+		lock.Lock()
+		n := len(queue)
+		if n > c.BatchSize {
+			n = c.BatchSize
+		}
+		batch := queue[:n:n]
+		queue = queue[n:]
+		lock.Unlock()
+
+		if len(batch) == 0 {
+			break
+		}
+
+		err := send(batch)
+		if _, ok := err.(permanentError); ok {
+			log.Printf("remote_write: dropping %d samples: %v", len(batch), err)
+			sent = true
+			continue
+		}
+		if err != nil {
+			log.Printf("remote_write: unable to send, will retry: %v", err)
+			lock.Lock()
+			queue = append(batch, queue...)
+			if dropped := len(queue) - c.BufferSize; dropped > 0 {
+				queue = queue[dropped:]
+			}
+			lock.Unlock()
+			break
+		}
+		sent = true
+	}
+
+	if !sent {
+		return
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if err := rewrite(); err != nil {
+		log.Printf("remote_write: unable to save %s: %v", filename(), err)
+	}
+}
+
+func send(batch []Sample) error {
+	c := config.Config.RemoteWrite
+	req, err := http.NewRequest("POST", c.URL, bytes.NewReader(snappy(encode(batch))))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "weather-exporter")
+	for name, value := range c.Headers {
+		req.Header.Set(name, value)
+	}
+	switch {
+	case c.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+c.BearerToken)
+	case c.Username != "":
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s", resp.Status)
+	if len(bytes.TrimSpace(message)) > 0 {
+		err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+
+	// Out of order and duplicate samples are rejected with a 400, retrying would never succeed
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return permanentError{err}
+	}
+	return err
+}
+
+// appendLog adds samples to the end of the write ahead log
+func appendLog(samples []Sample) error {
+	if wal == nil {
+		if err := os.MkdirAll(config.Config.DataDir, 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(filename(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		wal = f
+	}
+
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	for _, s := range samples {
+		if err := encoder.Encode(s); err != nil {
+			return err
+		}
+	}
+	_, err := wal.Write(b.Bytes())
+	return err
+}
+
+// rewrite replaces the write ahead log with the queued samples, the log is reopened on the next
+// append
+func rewrite() error {
+	if wal != nil {
+		wal.Close()
+		wal = nil
+	}
+	if len(queue) == 0 {
+		if err := os.Remove(filename()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(config.Config.DataDir, 0755); err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	for _, s := range queue {
+		if err := encoder.Encode(s); err != nil {
+			return err
+		}
+	}
+
+	tmp := filename() + ".tmp"
+	if err := os.WriteFile(tmp, b.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename())
+}
@@ -0,0 +1,37 @@
+package remotewrite
+
+import "neverending.dev/weather/protobuf"
+
+/*
+ * Snappy block format, which remote write requests are compressed with. Only literals are written,
+ * so the data is not made any smaller, but it is valid snappy that any decoder accepts and keeps
+ * this free of a compression library. The requests are small and sent over a local network.
+ *
+ * https://github.com/google/snappy/blob/main/format_description.txt
+ */
+
+// maxLiteral is the longest literal written, the largest with a two byte length
+const maxLiteral = 1 << 16
+
+func snappy(data []byte) []byte {
+	b := protobuf.AppendVarint(nil, uint64(len(data)))
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxLiteral {
+			n = maxLiteral
+		}
+
+		// The tag holds the length less one, in the tag itself below 60 or in the following bytes
+		switch l := n - 1; {
+		case l < 60:
+			b = append(b, byte(l<<2))
+		case l < 1<<8:
+			b = append(b, 60<<2, byte(l))
+		default:
+			b = append(b, 61<<2, byte(l), byte(l>>8))
+		}
+		b = append(b, data[:n]...)
+		data = data[n:]
+	}
+	return b
+}
@@ -0,0 +1,263 @@
+package sensors
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"neverending.dev/weather/airgradient"
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/ecowitt"
+	"neverending.dev/weather/metrics"
+	"neverending.dev/weather/state"
+)
+
+/*
+ * Registry of the sensors described in the configuration. Sensors are identified by station and
+ * sensor name: gateway, outdoor, lightning, th<channel> and soil<channel> for the ecowitt gateway
+ * and the station ID for an AirGradient. Sensors that are not described are still reported under
+ * their channel number. A sensor is up while it has been included in a report within the
+ * configured sensor_timeout.
+ */
+
+// Lookup returns the description of a sensor, false if it is not configured
+func Lookup(station string, sensor string) (config.Sensor, bool) {
+	for _, s := range config.Config.Sensors {
+		if s.Station == station && s.Sensor == sensor {
+			return s, true
+		}
+	}
+	return config.Sensor{Station: station, Sensor: sensor}, false
+}
+
+// Name returns the configured name of a sensor, or a name made from the sensor and channel
+func Name(station string, sensor string) string {
+	if s, ok := Lookup(station, sensor); ok && s.Name != "" {
+		return s.Name
+	}
+
+	var channel int
+	if _, err := fmt.Sscanf(sensor, "th%d", &channel); err == nil {
+		return fmt.Sprintf("Temperature/Humidity %d", channel)
+	}
+	if _, err := fmt.Sscanf(sensor, "soil%d", &channel); err == nil {
+		return fmt.Sprintf("Soil Moisture %d", channel)
+	}
+	if sensor == "" {
+		return station
+	}
+	return strings.ToUpper(sensor[:1]) + sensor[1:]
+}
+
+// Tags returns the configured description of a sensor as label names and values, empty for an
+// unconfigured sensor
+func Tags(station string, sensor string) map[string]string {
+	tags := map[string]string{}
+	s, ok := Lookup(station, sensor)
+	if !ok {
+		return tags
+	}
+
+	if s.Name != "" {
+		tags["name"] = s.Name
+	}
+	if s.Zone != "" {
+		tags["zone"] = s.Zone
+	}
+	if s.Indoor != nil {
+		tags["indoor"] = fmt.Sprintf("%t", *s.Indoor)
+	}
+	if s.Floor != nil {
+		tags["floor"] = fmt.Sprintf("%d", *s.Floor)
+	}
+	for tag, value := range s.Tags {
+		tags[tag] = value
+	}
+	return tags
+}
+
+// Labels returns the Prometheus labels for a sensor's metrics, including the extra label name and
+// value pairs given first. An unconfigured sensor with no extra labels has no labels.
+func Labels(station string, sensor string, extra ...string) string {
+	labels := []string{}
+	for i := 0; i+1 < len(extra); i += 2 {
+		labels = append(labels, metrics.Label(extra[i], extra[i+1]))
+	}
+
+	tags := Tags(station, sensor)
+	names := []string{}
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		labels = append(labels, metrics.Label(name, tags[name]))
+	}
+
+	if len(labels) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// Sensor is a sensor's description along with whether the station is reporting it
+type Sensor struct {
+	Station string            `json:"station"`
+	Sensor  string            `json:"sensor"`
+	Channel int               `json:"channel,omitempty"`
+	Name    string            `json:"name"`
+	Zone    string            `json:"zone,omitempty"`
+	Indoor  *bool             `json:"indoor,omitempty"`
+	Floor   *int              `json:"floor,omitempty"`
+	Tags    map[string]string `json:"tags,omitempty"`
+	Active  bool              `json:"active"`
+	Up      bool              `json:"up"`
+	Seen    *time.Time        `json:"last_seen,omitempty"`
+	Battery string            `json:"battery,omitempty"` // ok, low or critical
+	Voltage float64           `json:"voltage,omitempty"`
+}
+
+// LastSeen returns when a sensor was last included in a report, false if it has not been seen
+func LastSeen(station string, sensor string) (t time.Time, ok bool) {
+	state.Read(func() {
+		switch station {
+		case "ecowitt":
+			t, ok = ecowitt.WS.LastSeen[sensor]
+		case "airgradient":
+			if sensor == airgradient.AG.ID && !airgradient.AG.LastSeen.IsZero() {
+				t, ok = airgradient.AG.LastSeen, true
+			}
+		}
+	})
+	return t, ok
+}
+
+// Up reports whether a sensor has been seen within the sensor timeout
+func Up(station string, sensor string) bool {
+	t, ok := LastSeen(station, sensor)
+	return ok && time.Since(t) < config.SensorTimeout()
+}
+
+// Battery returns the battery health of a sensor, false if the sensor does not report a battery
+func Battery(station string, sensor string) (b ecowitt.Battery, ok bool) {
+	if station != "ecowitt" {
+		return ecowitt.Battery{}, false
+	}
+	state.Read(func() { b, ok = ecowitt.WS.Batteries()[sensor] })
+	return b, ok
+}
+
+// Describe returns the description of a sensor for the JSON API
+func Describe(station string, sensor string, channel int, active bool) Sensor {
+	s, _ := Lookup(station, sensor)
+	d := Sensor{
+		Station: station,
+		Sensor:  sensor,
+		Channel: channel,
+		Name:    Name(station, sensor),
+		Zone:    s.Zone,
+		Indoor:  s.Indoor,
+		Floor:   s.Floor,
+		Tags:    s.Tags,
+		Active:  active,
+		Up:      Up(station, sensor),
+	}
+	if t, ok := LastSeen(station, sensor); ok {
+		d.Seen = &t
+	}
+	if b, ok := Battery(station, sensor); ok {
+		d.Battery = b.State.String()
+		d.Voltage = b.Voltage
+	}
+	return d
+}
+
+// channels are the prefixes of the ecowitt sensors that report on a channel, e.g. th1
+var channels = []string{"th", "soil", "leak"}
+
+// All returns every configured sensor and every sensor the stations have reported, including the
+// channels that have since stopped reporting
+func All() []Sensor {
+	all := []Sensor{}
+	added := map[string]bool{}
+	add := func(station string, sensor string, channel int, active bool) {
+		if !added[station+"/"+sensor] {
+			added[station+"/"+sensor] = true
+			all = append(all, Describe(station, sensor, channel, active))
+		}
+	}
+
+	ws := ecowitt.Snapshot()
+	if ws.Status == ecowitt.Ready {
+		add("ecowitt", "gateway", 0, true)
+		add("ecowitt", "outdoor", 0, true)
+		if _, ok := ws.LastSeen["lightning"]; ok {
+			add("ecowitt", "lightning", 0, true)
+		}
+		for _, prefix := range channels {
+			for i := 1; i <= 8; i++ {
+				if _, ok := ws.LastSeen[fmt.Sprintf("%s%d", prefix, i)]; ok {
+					add("ecowitt", fmt.Sprintf("%s%d", prefix, i), i, true)
+				}
+			}
+		}
+	}
+	if ag := airgradient.Snapshot(); ag.Status == airgradient.Ready {
+		add("airgradient", ag.ID, 0, true)
+	}
+
+	for _, s := range config.Config.Sensors {
+		channel := 0
+		for _, prefix := range channels {
+			if _, err := fmt.Sscanf(s.Sensor, prefix+"%d", &channel); err == nil {
+				break
+			}
+		}
+		add(s.Station, s.Sensor, channel, false)
+	}
+
+	return all
+}
+
+// Report adds whether each sensor is up, when it was last seen and its battery health to the
+// exporter report. The battery state has a series for each state, set to 1 for the current one.
+func Report(report map[string]string) {
+	states := []ecowitt.BatteryState{ecowitt.BatteryOK, ecowitt.BatteryLow, ecowitt.BatteryCritical}
+
+	for _, s := range All() {
+		labels := Labels(s.Station, s.Sensor, "station", s.Station, "sensor", s.Sensor)
+		report["weather_sensor_up"+labels] = "0"
+		if s.Up {
+			report["weather_sensor_up"+labels] = "1"
+		}
+		if s.Seen != nil {
+			report["weather_sensor_last_seen_seconds"+labels] = fmt.Sprintf("%d", s.Seen.Unix())
+		}
+
+		b, ok := Battery(s.Station, s.Sensor)
+		if !ok {
+			continue
+		}
+		for _, state := range states {
+			keystr := "weather_sensor_battery_state" + Labels(s.Station, s.Sensor, "station", s.Station, "sensor", s.Sensor, "state", state.String())
+			report[keystr] = "0"
+			if b.State == state {
+				report[keystr] = "1"
+			}
+		}
+		if b.Voltage > 0 {
+			report["weather_sensor_battery_voltage"+labels] = fmt.Sprintf("%.2f", b.Voltage)
+		}
+	}
+}
+
+// Handler serves the sensor registry as JSON
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(All()); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
@@ -0,0 +1,42 @@
+package state
+
+import "sync"
+
+/*
+ * Guards the stations, which are updated from the HTTP handlers and the MQTT client at the same
+ * time as the outputs read them. Reports are ingested one at a time, listeners included, so the
+ * listeners of a report see the stations as it left them and can read them without locking.
+ * Anything else reading the stations does so through Read, or a snapshot taken with it.
+ *
+ * Only the stations are guarded here. The state listeners derive from them, such as records,
+ * history, degree days, ETo, irrigation, frost and lightning, is read by the metrics and the API
+ * while reports are ingested, so each of those packages guards its own with a lock.
+ */
+
+// reports serialises the ingest of reports and their listeners
+var reports sync.Mutex
+
+// stations is held for writing while a report updates the stations
+var stations sync.RWMutex
+
+// Ingest calls update with the stations locked for writing, then each listener. No other report
+// is ingested until the listeners have returned.
+func Ingest(update func(), listeners []func()) {
+	reports.Lock()
+	defer reports.Unlock()
+
+	stations.Lock()
+	update()
+	stations.Unlock()
+
+	for _, f := range listeners {
+		f()
+	}
+}
+
+// Read calls f with the stations locked against updates
+func Read(f func()) {
+	stations.RLock()
+	defer stations.RUnlock()
+	f()
+}
@@ -0,0 +1,79 @@
+package statsd
+
+import (
+	"log"
+	"net"
+	"strconv"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/graphite"
+	"neverending.dev/weather/readings"
+)
+
+/*
+ * Sends each reading to StatsD as a gauge as it is received, over UDP with as many readings in
+ * each packet as fit. StatsD treats a gauge value with a sign as a change to the gauge, so a
+ * negative reading is sent as a reset to 0 followed by the change.
+ */
+
+// maxPacket keeps packets within the MTU of most networks
+const maxPacket = 1432
+
+var conn net.Conn
+
+// Start sends the readings after each report
+func Start() {
+	c := config.Config.StatsD
+	if c.Address == "" {
+		return
+	}
+
+	var err error
+	if conn, err = net.Dial("udp", c.Address); err != nil {
+		log.Printf("statsd: unable to use %s: %v", c.Address, err)
+		return
+	}
+	readings.OnReport(send)
+}
+
+// Gauge returns the lines setting a gauge to value
+func Gauge(path string, value float64) []string {
+	v := strconv.FormatFloat(value, 'f', -1, 64)
+	if value < 0 {
+		return []string{path + ":0|g", path + ":" + v + "|g"}
+	}
+	return []string{path + ":" + v + "|g"}
+}
+
+func send(received []readings.Reading) {
+	c := config.Config.StatsD
+	packet := []byte{}
+	for _, r := range received {
+		lines := Gauge(graphite.Path(c.Prefix, c.Template, r), r.Value)
+
+		// The reset and change of a negative gauge are kept in the same packet
+		size := 0
+		for _, line := range lines {
+			size += len(line) + 1
+		}
+		if len(packet) > 0 && len(packet)+size > maxPacket {
+			write(packet)
+			packet = []byte{}
+		}
+		for _, line := range lines {
+			if len(packet) > 0 {
+				packet = append(packet, '\n')
+			}
+			packet = append(packet, line...)
+		}
+	}
+	if len(packet) > 0 {
+		write(packet)
+	}
+}
+
+func write(packet []byte) {
+	if _, err := conn.Write(packet); err != nil {
+		log.Printf("statsd: unable to send to %s: %v", config.Config.StatsD.Address, err)
+	}
+}
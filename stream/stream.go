@@ -0,0 +1,220 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"neverending.dev/weather/airgradient"
+	"neverending.dev/weather/current"
+	"neverending.dev/weather/ecowitt"
+)
+
+/*
+ * Streams the current conditions of a station each time it reports, over Server-Sent Events or a
+ * WebSocket, as the same JSON as /api/v1/stations/{id}/current. Clients are sent the latest
+ * conditions of every station when they connect and can be limited to some stations with
+ * ?station=, and choose their units as with the current conditions API.
+ *
+ * Updates are queued for each client without waiting for it. A client that is slower than the
+ * stations report only gets the latest update of each station, so a slow or stalled client never
+ * holds up a report or the other clients.
+ */
+
+const (
+	writeTimeout = 10 * time.Second
+
+	// heartbeat keeps idle connections open through proxies
+	heartbeat = 30 * time.Second
+)
+
+// client is a connected stream
+type client struct {
+	units    current.Units
+	stations []string // names or IDs of the stations to send, every station when empty
+
+	lock    sync.Mutex
+	pending map[string][]byte // the latest update of each station not yet sent
+	order   []string
+	wake    chan struct{}
+}
+
+var clients = map[*client]bool{}
+var lock sync.Mutex
+
+// Start streams the updates after each report
+func Start() {
+	ecowitt.OnReport(func() { broadcast("ecowitt") })
+	airgradient.OnReport(func() { broadcast("airgradient") })
+}
+
+func newClient(r *http.Request) (*client, error) {
+	u, err := current.ParseUnits(r.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	c := &client{
+		units:   u,
+		pending: map[string][]byte{},
+		wake:    make(chan struct{}, 1),
+	}
+	for _, station := range strings.Split(r.URL.Query().Get("station"), ",") {
+		if station = strings.TrimSpace(station); station != "" {
+			c.stations = append(c.stations, station)
+		}
+	}
+	return c, nil
+}
+
+// wants reports whether the client asked for a station
+func (c *client) wants(s current.Station) bool {
+	if len(c.stations) == 0 {
+		return true
+	}
+	for _, id := range c.stations {
+		if s.Matches(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// queue replaces any update of the station waiting to be sent
+func (c *client) queue(station string, update []byte) {
+	c.lock.Lock()
+	if _, ok := c.pending[station]; !ok {
+		c.order = append(c.order, station)
+	}
+	c.pending[station] = update
+	c.lock.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the updates waiting to be sent, oldest first
+func (c *client) next() [][]byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	updates := [][]byte{}
+	for _, station := range c.order {
+		updates = append(updates, c.pending[station])
+	}
+	c.pending = map[string][]byte{}
+	c.order = nil
+	return updates
+}
+
+// update returns the JSON update of a station in the units, false if it has not reported
+func update(station string, u current.Units) (current.Station, []byte, bool) {
+	s, ok := current.Of(station, u)
+	if !ok {
+		return s, nil, false
+	}
+	data, err := json.Marshal(current.Update{Time: time.Now().UTC(), Units: u, Station: s})
+	if err != nil {
+		log.Printf("stream: unable to encode %s: %v", station, err)
+		return s, nil, false
+	}
+	return s, data, true
+}
+
+// broadcast queues the conditions of a station for every client that wants them, encoding them
+// once for each set of units asked for
+func broadcast(station string) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	type encoded struct {
+		station current.Station
+		data    []byte
+		ok      bool
+	}
+	updates := map[current.Units]encoded{}
+	for c := range clients {
+		e, done := updates[c.units]
+		if !done {
+			e.station, e.data, e.ok = update(station, c.units)
+			updates[c.units] = e
+		}
+		if e.ok && c.wants(e.station) {
+			c.queue(station, e.data)
+		}
+	}
+}
+
+// connect adds a client, queueing the latest conditions of each station it wants
+func connect(c *client) {
+	for _, station := range []string{"ecowitt", "airgradient"} {
+		if s, data, ok := update(station, c.units); ok && c.wants(s) {
+			c.queue(station, data)
+		}
+	}
+
+	lock.Lock()
+	clients[c] = true
+	lock.Unlock()
+}
+
+func disconnect(c *client) {
+	lock.Lock()
+	delete(clients, c)
+	lock.Unlock()
+}
+
+// Handler streams updates as Server-Sent Events, or over a WebSocket when the request is a
+// WebSocket upgrade
+func Handler(w http.ResponseWriter, r *http.Request) {
+	c, err := newClient(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		serveWebSocket(w, r, c)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	connect(c)
+	defer disconnect(c)
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-c.wake:
+			for _, data := range c.next() {
+				if _, err := fmt.Fprintf(w, "event: update\ndata: %s\n\n", data); err != nil {
+					return
+				}
+			}
+		}
+		flusher.Flush()
+	}
+}
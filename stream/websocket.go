@@ -0,0 +1,185 @@
+package stream
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * A minimal WebSocket server, enough to send updates as text messages. Messages from the client
+ * are read only to answer pings and close the connection.
+ *
+ * RFC 6455 The WebSocket Protocol
+ */
+
+// guid is appended to the client's key to accept the handshake
+const guid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// opcodes
+const (
+	opText  = 0x1
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xa
+)
+
+// maxFrame is the largest frame read from a client, which has no reason to send more than a
+// close or ping
+const maxFrame = 1 << 16
+
+type websocket struct {
+	conn net.Conn
+	lock sync.Mutex
+}
+
+// accept returns the Sec-WebSocket-Accept for a client's key
+func accept(key string) string {
+	h := sha1.Sum([]byte(key + guid))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func (ws *websocket) write(opcode byte, payload []byte) error {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+
+	frame := []byte{0x80 | opcode} // final frame, server frames are not masked
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n < 1<<16:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		length := make([]byte, 8)
+		binary.BigEndian.PutUint64(length, uint64(n))
+		frame = append(append(frame, 127), length...)
+	}
+
+	ws.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := ws.conn.Write(append(frame, payload...))
+	return err
+}
+
+// read returns the opcode and payload of the next frame from the client
+func read(r *bufio.Reader) (byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		b := make([]byte, 2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(b))
+	case 127:
+		b := make([]byte, 8)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(b)
+	}
+	if length > maxFrame {
+		return 0, nil, errors.New("frame too large")
+	}
+
+	mask := make([]byte, 4)
+	if masked {
+		if _, err := io.ReadFull(r, mask); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// serveWebSocket completes the handshake and sends updates until the client goes away
+func serveWebSocket(w http.ResponseWriter, r *http.Request, c *client) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != "GET" || key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" ||
+		!strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "not a WebSocket handshake", 400)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket is not supported", 500)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	ws := &websocket{conn: conn}
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + accept(key) + "\r\n\r\n"
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := conn.Write([]byte(response)); err != nil {
+		return
+	}
+
+	connect(c)
+	defer disconnect(c)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			opcode, payload, err := read(rw.Reader)
+			if err != nil {
+				return
+			}
+			switch opcode {
+			case opClose:
+				ws.write(opClose, payload)
+				return
+			case opPing:
+				ws.write(opPong, payload)
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := ws.write(opPing, nil); err != nil {
+				return
+			}
+		case <-c.wake:
+			for _, data := range c.next() {
+				if err := ws.write(opText, data); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
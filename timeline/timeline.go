@@ -0,0 +1,228 @@
+package timeline
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/current"
+	"neverending.dev/weather/measurement/Pressure"
+	"neverending.dev/weather/measurement/Rainfall"
+	"neverending.dev/weather/measurement/Temperature"
+	"neverending.dev/weather/measurement/Velocity"
+	"neverending.dev/weather/readings"
+)
+
+/*
+ * Every reading over the last 24 hours at 5 minute resolution, for charts. Each point is the mean
+ * of the readings received in its 5 minutes, except for the rain totals and strike count, which
+ * are the last reading so they do not lag behind a reset.
+ */
+
+const (
+	resolution = 5 * time.Minute
+	window     = 24 * time.Hour
+)
+
+// cumulative readings are charted by their last value rather than the mean
+var cumulative = map[string]bool{
+	"rain_event": true, "rain_hourly": true, "rain_daily": true, "rain_weekly": true,
+	"rain_monthly": true, "rain_yearly": true, "rain_total": true, "strikes": true,
+}
+
+// Point is the value of a reading over 5 minutes
+type Point struct {
+	Time    time.Time `json:"time"` // start of the 5 minutes
+	Value   float64   `json:"value"`
+	Samples int       `json:"samples"`
+}
+
+// Series is a reading of a sensor over time, in the units of the exporter
+type Series struct {
+	Station  string  `json:"station"`
+	Sensor   string  `json:"sensor"`
+	Field    string  `json:"field"`
+	Quantity string  `json:"quantity"`
+	Unit     string  `json:"unit"`
+	Points   []Point `json:"points"`
+}
+
+var series = []*Series{}
+var lock sync.Mutex
+
+func filename() string {
+	return filepath.Join(config.Config.DataDir, "timeline.json")
+}
+
+// Start loads the persisted timeline and adds the readings after each report
+func Start() {
+	if data, err := os.ReadFile(filename()); err == nil {
+		if err := json.Unmarshal(data, &series); err != nil {
+			log.Printf("timeline: unable to load %s: %v", filename(), err)
+		}
+	}
+
+	readings.OnReport(Update)
+}
+
+func find(r readings.Reading) *Series {
+	for _, s := range series {
+		if s.Station == r.Station && s.Sensor == r.Sensor && s.Field == r.Field {
+			return s
+		}
+	}
+	s := &Series{Station: r.Station, Sensor: r.Sensor, Field: r.Field, Quantity: r.Quantity, Unit: r.Unit}
+	series = append(series, s)
+	return s
+}
+
+// Update adds the readings of a report, saving the timeline each time a new 5 minutes starts
+func Update(received []readings.Reading) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	started := false
+	for _, r := range received {
+		s := find(r)
+		t := r.Time.Truncate(resolution)
+
+		n := len(s.Points)
+		switch {
+		case n > 0 && s.Points[n-1].Time.Equal(t):
+			p := &s.Points[n-1]
+			p.Samples++
+			if cumulative[r.Field] {
+				p.Value = r.Value
+			} else {
+				p.Value += (r.Value - p.Value) / float64(p.Samples)
+			}
+		case n == 0 || s.Points[n-1].Time.Before(t):
+			s.Points = append(s.Points, Point{Time: t, Value: r.Value, Samples: 1})
+			started = true
+		}
+	}
+
+	if !started {
+		return
+	}
+
+	// Drop the points and series that have fallen out of the window
+	cutoff := time.Now().Add(-window)
+	kept := []*Series{}
+	for _, s := range series {
+		i := 0
+		for i < len(s.Points) && s.Points[i].Time.Before(cutoff) {
+			i++
+		}
+		s.Points = s.Points[i:]
+		if len(s.Points) > 0 {
+			kept = append(kept, s)
+		}
+	}
+	series = kept
+
+	if err := save(); err != nil {
+		log.Printf("timeline: unable to save %s: %v", filename(), err)
+	}
+}
+
+func save() error {
+	data, err := json.Marshal(series)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(config.Config.DataDir, 0755); err != nil {
+		return err
+	}
+
+	tmp := filename() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename())
+}
+
+// convert returns a reading in the units, false if the quantity has no unit to convert
+func convert(quantity string, value float64, u current.Units) (float64, string, bool) {
+	switch quantity {
+	case "temperature":
+		return Temperature.New(value, Temperature.Celsius).Get(u.Temperature), u.Temperature.String(), true
+	case "pressure":
+		return Pressure.New(value, Pressure.Hectopascal).Get(u.Pressure), u.Pressure.String(), true
+	case "wind":
+		return Velocity.New(value, Velocity.KilometresPerHour).Get(u.Wind), u.Wind.String(), true
+	case "rain":
+		return Rainfall.New(value, Rainfall.Millimetre).Get(u.Rain), u.Rain.String(), true
+	case "rain_rate":
+		return Rainfall.New(value, Rainfall.Millimetre).Get(u.Rain), u.Rain.String() + "/h", true
+	}
+	return value, "", false
+}
+
+// Get returns the series over the last hours matching the filter, an empty filter value matches
+// every station, sensor or field. Values are given in the units.
+func Get(station string, sensor string, field string, hours float64, u current.Units) []Series {
+	lock.Lock()
+	defer lock.Unlock()
+
+	cutoff := time.Now().Add(-time.Duration(hours * float64(time.Hour)))
+	matching := []Series{}
+	for _, s := range series {
+		if (station != "" && s.Station != station) || (sensor != "" && s.Sensor != sensor) || (field != "" && s.Field != field) {
+			continue
+		}
+
+		c := Series{Station: s.Station, Sensor: s.Sensor, Field: s.Field, Quantity: s.Quantity, Unit: s.Unit, Points: []Point{}}
+		for _, p := range s.Points {
+			if p.Time.Before(cutoff) {
+				continue
+			}
+			if v, unit, ok := convert(s.Quantity, p.Value, u); ok {
+				p.Value, c.Unit = v, unit
+			}
+			p.Value = math.Round(p.Value*100) / 100
+			c.Points = append(c.Points, p)
+		}
+		matching = append(matching, c)
+	}
+	return matching
+}
+
+// Handler serves the timeline as JSON, filtered by ?station=, ?sensor= and ?field=, a comma
+// separated list of fields, over the last ?hours=, 24 by default. Units are chosen as for the
+// current conditions.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	u, err := current.ParseUnits(query)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	hours := window.Hours()
+	if h := query.Get("hours"); h != "" {
+		if hours, err = strconv.ParseFloat(h, 64); err != nil || hours <= 0 {
+			http.Error(w, "invalid hours", 400)
+			return
+		}
+	}
+
+	result := []Series{}
+	for _, field := range strings.Split(query.Get("field"), ",") {
+		result = append(result, Get(query.Get("station"), query.Get("sensor"), strings.TrimSpace(field), hours, u)...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
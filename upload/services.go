@@ -0,0 +1,185 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/frost"
+	"neverending.dev/weather/measurement/Pressure"
+	"neverending.dev/weather/measurement/Rainfall"
+	"neverending.dev/weather/measurement/Temperature"
+	"neverending.dev/weather/measurement/Velocity"
+)
+
+// service builds the uploads of a weather network
+type service struct {
+	endpoint string
+	request  func(endpoint string, u config.Upload, o observation) (*http.Request, error)
+	accepted func(body string) error // checks a successful response, when the status is not enough
+}
+
+var services = map[string]service{
+	"wunderground": {
+		endpoint: "https://weatherstation.wunderground.com/weatherstation/updateweatherstation.php",
+		request:  wunderground,
+		accepted: func(body string) error {
+			if !strings.Contains(strings.ToLower(body), "success") {
+				return fmt.Errorf("%s", strings.TrimSpace(body))
+			}
+			return nil
+		},
+	},
+	"pwsweather": {
+		endpoint: "https://pwsupdate.pwsweather.com/api/v1/submitwx",
+		request:  wunderground,
+	},
+	"wow": {
+		endpoint: "https://wow.metoffice.gov.uk/automaticreading",
+		request:  wow,
+	},
+	"windy": {
+		endpoint: "https://stations.windy.com/pws/update",
+		request:  windy,
+	},
+	"openweathermap": {
+		endpoint: "https://api.openweathermap.org/data/3.0/measurements",
+		request:  openweathermap,
+	},
+}
+
+// set adds a value to the query, rounded to the digits, unless it was not reported
+func set(query url.Values, name string, value float64, digits int) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+	query.Set(name, strconv.FormatFloat(value, 'f', digits, 64))
+}
+
+func fahrenheit(celsius float64) float64 {
+	return Temperature.New(celsius, Temperature.Celsius).Get(Temperature.Farenheit)
+}
+
+func mph(kmh float64) float64 {
+	return Velocity.New(kmh, Velocity.KilometresPerHour).Get(Velocity.MilesPerHour)
+}
+
+func metresPerSecond(kmh float64) float64 {
+	return Velocity.New(kmh, Velocity.KilometresPerHour).Get(Velocity.MetresPerSecond)
+}
+
+func inches(mm float64) float64 {
+	return Rainfall.New(mm, Rainfall.Millimetre).Get(Rainfall.Inch)
+}
+
+// imperial returns the readings as the query fields of the Weather Underground upload protocol,
+// which PWSWeather and WOW also accept
+func imperial(o observation) url.Values {
+	query := url.Values{}
+	query.Set("dateutc", o.time.UTC().Format("2006-01-02 15:04:05"))
+	query.Set("softwaretype", "weather")
+
+	temperature, humidity := o.get(o.outdoor, "temperature"), o.get(o.outdoor, "humidity")
+
+	set(query, "tempf", fahrenheit(temperature), 1)
+	set(query, "humidity", humidity, 0)
+	set(query, "dewptf", fahrenheit(frost.DewPoint(temperature, humidity)), 1)
+	set(query, "baromin", Pressure.New(o.get(o.indoor, "pressure_relative"), Pressure.Hectopascal).Get(Pressure.InchOfMercury), 3)
+	set(query, "windspeedmph", mph(o.get(o.outdoor, "wind_speed")), 1)
+	set(query, "windgustmph", mph(o.get(o.outdoor, "wind_gust")), 1)
+	set(query, "winddir", o.get(o.outdoor, "wind_direction"), 0)
+	set(query, "rainin", inches(o.get(o.outdoor, "rain_hourly")), 3)
+	set(query, "dailyrainin", inches(o.get(o.outdoor, "rain_daily")), 3)
+	set(query, "weeklyrainin", inches(o.get(o.outdoor, "rain_weekly")), 3)
+	set(query, "monthlyrainin", inches(o.get(o.outdoor, "rain_monthly")), 3)
+	set(query, "yearlyrainin", inches(o.get(o.outdoor, "rain_yearly")), 3)
+	set(query, "solarradiation", o.get(o.outdoor, "solar_radiation"), 1)
+	set(query, "UV", o.get(o.outdoor, "uv"), 0)
+	set(query, "indoortempf", fahrenheit(o.get(o.indoor, "temperature")), 1)
+	set(query, "indoorhumidity", o.get(o.indoor, "humidity"), 0)
+	return query
+}
+
+// wunderground uploads to Weather Underground, or PWSWeather which uses the same protocol
+func wunderground(endpoint string, u config.Upload, o observation) (*http.Request, error) {
+	query := imperial(o)
+	query.Set("ID", u.Station)
+	query.Set("PASSWORD", u.Key)
+	query.Set("action", "updateraw")
+	return http.NewRequest("GET", endpoint+"?"+query.Encode(), nil)
+}
+
+// wow uploads to the Met Office Weather Observations Website. WOW takes rainin as the rain since
+// the previous upload rather than over the last hour, so only the daily rain is sent.
+func wow(endpoint string, u config.Upload, o observation) (*http.Request, error) {
+	query := imperial(o)
+	query.Del("rainin")
+	query.Set("siteid", u.Station)
+	query.Set("siteAuthenticationKey", u.Key)
+	return http.NewRequest("GET", endpoint+"?"+query.Encode(), nil)
+}
+
+// windy uploads to the Windy stations API, in metric units with the key in the path
+func windy(endpoint string, u config.Upload, o observation) (*http.Request, error) {
+	station := u.Station
+	if station == "" {
+		station = "0"
+	}
+	temperature, humidity := o.get(o.outdoor, "temperature"), o.get(o.outdoor, "humidity")
+	query := url.Values{}
+	query.Set("station", station)
+	query.Set("dateutc", o.time.UTC().Format("2006-01-02 15:04:05"))
+	set(query, "temp", temperature, 1)
+	set(query, "humidity", humidity, 0)
+	set(query, "dewpoint", frost.DewPoint(temperature, humidity), 1)
+	set(query, "pressure", Pressure.New(o.get(o.indoor, "pressure_relative"), Pressure.Hectopascal).Get(Pressure.Pascal), 0)
+	set(query, "wind", metresPerSecond(o.get(o.outdoor, "wind_speed")), 1)
+	set(query, "gust", metresPerSecond(o.get(o.outdoor, "wind_gust")), 1)
+	set(query, "winddir", o.get(o.outdoor, "wind_direction"), 0)
+	set(query, "precip", o.get(o.outdoor, "rain_hourly"), 1)
+	set(query, "uv", o.get(o.outdoor, "uv"), 0)
+	set(query, "solarradiation", o.get(o.outdoor, "solar_radiation"), 1)
+
+	return http.NewRequest("GET", strings.TrimSuffix(endpoint, "/")+"/"+url.PathEscape(u.Key)+"?"+query.Encode(), nil)
+}
+
+// openweathermap uploads a measurement to the OpenWeatherMap stations API
+func openweathermap(endpoint string, u config.Upload, o observation) (*http.Request, error) {
+	temperature, humidity := o.get(o.outdoor, "temperature"), o.get(o.outdoor, "humidity")
+	measurement := map[string]interface{}{
+		"station_id": u.Station,
+		"dt":         o.time.Unix(),
+	}
+	values := map[string]float64{
+		"temperature": temperature,
+		"humidity":    humidity,
+		"dew_point":   frost.DewPoint(temperature, humidity),
+		"pressure":    o.get(o.indoor, "pressure_relative"),
+		"wind_speed":  metresPerSecond(o.get(o.outdoor, "wind_speed")),
+		"wind_gust":   metresPerSecond(o.get(o.outdoor, "wind_gust")),
+		"wind_deg":    o.get(o.outdoor, "wind_direction"),
+		"rain_1h":     o.get(o.outdoor, "rain_hourly"),
+	}
+	for name, value := range values {
+		if !math.IsNaN(value) && !math.IsInf(value, 0) {
+			measurement[name] = math.Round(value*100) / 100
+		}
+	}
+
+	body, err := json.Marshal([]interface{}{measurement})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", endpoint+"?appid="+url.QueryEscape(u.Key), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
@@ -0,0 +1,228 @@
+package upload
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"neverending.dev/weather/config"
+	"neverending.dev/weather/metrics"
+	"neverending.dev/weather/readings"
+)
+
+/*
+ * Re-publishes each observation of the Ecowitt station to weather networks, so the exporter can
+ * act as a hub for more services than the gateway uploads to itself. Each configured upload runs
+ * on its own, sending no more often than its interval. Observations received in between replace
+ * the one waiting to be sent, so a service is always sent the latest. Uploads that fail because
+ * the service is unavailable are retried with a backoff, those it rejects are dropped.
+ */
+
+const maxBackoff = 10 * time.Minute
+
+// minBackoff is the wait before the first retry of an upload
+var minBackoff = 10 * time.Second
+
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// retryable is an upload the service could not accept now but may later
+type retryable struct {
+	error
+}
+
+// observation is the outdoor and indoor readings of a report, in the units of the readings
+type observation struct {
+	time    time.Time
+	outdoor map[string]float64
+	indoor  map[string]float64
+}
+
+// uploader sends observations to one configured service
+type uploader struct {
+	config.Upload
+	service service
+
+	lock        sync.Mutex
+	pending     *observation
+	wake        chan struct{}
+	successes   int
+	failures    int
+	lastSuccess time.Time
+}
+
+var uploaders = []*uploader{}
+
+// Start uploads the observations after each report
+func Start() {
+	for _, u := range config.Config.Uploads {
+		up := &uploader{Upload: u, service: services[u.Service], wake: make(chan struct{}, 1)}
+		uploaders = append(uploaders, up)
+		go up.run()
+	}
+	if len(uploaders) == 0 {
+		return
+	}
+
+	readings.OnReport(observe)
+}
+
+// get returns a reading of a sensor, NaN when it was not reported
+func (o observation) get(sensor map[string]float64, field string) float64 {
+	if v, ok := sensor[field]; ok {
+		return v
+	}
+	return math.NaN()
+}
+
+// observe queues the readings of an Ecowitt report that includes the outdoor sensor array
+func observe(received []readings.Reading) {
+	o := observation{outdoor: map[string]float64{}, indoor: map[string]float64{}}
+	for _, r := range received {
+		switch {
+		case r.Station != "ecowitt":
+		case r.Sensor == "outdoor":
+			o.outdoor[r.Field] = r.Value
+			o.time = r.Time
+		case r.Sensor == "gateway":
+			o.indoor[r.Field] = r.Value
+		}
+	}
+	if len(o.outdoor) == 0 {
+		return
+	}
+
+	for _, u := range uploaders {
+		u.queue(o)
+	}
+}
+
+// queue replaces the observation waiting to be sent
+func (u *uploader) queue(o observation) {
+	u.lock.Lock()
+	u.pending = &o
+	u.lock.Unlock()
+
+	select {
+	case u.wake <- struct{}{}:
+	default:
+	}
+}
+
+// retry queues an observation again unless a newer one is waiting
+func (u *uploader) retry(o observation) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.pending == nil {
+		u.pending = &o
+	}
+}
+
+func (u *uploader) take() *observation {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	o := u.pending
+	u.pending = nil
+	return o
+}
+
+// run sends the latest observation no more often than the interval, backing off while the
+// service is unavailable
+func (u *uploader) run() {
+	backoff := minBackoff
+	next := time.Time{}
+	for range u.wake {
+		time.Sleep(time.Until(next))
+		o := u.take()
+		if o == nil {
+			continue
+		}
+
+		err := u.send(*o)
+
+		u.lock.Lock()
+		if err == nil {
+			u.successes++
+			u.lastSuccess = time.Now()
+		} else {
+			u.failures++
+		}
+		u.lock.Unlock()
+
+		if _, ok := err.(retryable); ok {
+			log.Printf("upload: unable to upload to %s, retrying in %s: %v", u.Service, backoff, err)
+			u.retry(*o)
+			next = time.Now().Add(backoff)
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			select {
+			case u.wake <- struct{}{}:
+			default:
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("upload: %s rejected the upload: %v", u.Service, err)
+		}
+		next = time.Now().Add(u.Every())
+		backoff = minBackoff
+	}
+}
+
+// endpoint returns the URL uploads are sent to
+func (u *uploader) endpoint() string {
+	if u.URL != "" {
+		return u.URL
+	}
+	return u.service.endpoint
+}
+
+func (u *uploader) send(o observation) error {
+	req, err := u.service.request(u.endpoint(), u.Upload, o)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "weather")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// the error includes the URL, which may include the key
+		if e, ok := err.(*url.Error); ok {
+			err = e.Err
+		}
+		return retryable{err}
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		if u.service.accepted != nil {
+			return u.service.accepted(string(body))
+		}
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return retryable{fmt.Errorf("%s: %s", resp.Status, body)}
+	}
+	return fmt.Errorf("%s: %s", resp.Status, body)
+}
+
+// Report adds the number of uploads to each service that succeeded and failed, and the time of
+// the last success
+func Report(report map[string]string) {
+	for _, u := range uploaders {
+		u.lock.Lock()
+		labels := metrics.Label("service", u.Service) + "," + metrics.Label("station", u.Station)
+		report[fmt.Sprintf("weather_uploads_total{%s,result=\"success\"}", labels)] = fmt.Sprintf("%d", u.successes)
+		report[fmt.Sprintf("weather_uploads_total{%s,result=\"failure\"}", labels)] = fmt.Sprintf("%d", u.failures)
+		if !u.lastSuccess.IsZero() {
+			report[fmt.Sprintf("weather_upload_last_success_timestamp_seconds{%s}", labels)] = fmt.Sprintf("%d", u.lastSuccess.Unix())
+		}
+		u.lock.Unlock()
+	}
+}
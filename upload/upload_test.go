@@ -0,0 +1,325 @@
+package upload
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"neverending.dev/weather/config"
+)
+
+// request is an upload received by a stub service
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   []byte
+	at     time.Time
+}
+
+// stub is a service answering each upload with the next status and body, the last one once
+// the responses run out
+type stub struct {
+	*httptest.Server
+	requests chan request
+}
+
+type response struct {
+	status int
+	body   string
+}
+
+func newStub(t *testing.T, responses ...response) *stub {
+	t.Helper()
+	s := &stub{requests: make(chan request, 100)}
+	n := 0
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.requests <- request{method: r.Method, path: r.URL.Path, query: r.URL.Query(), header: r.Header, body: body, at: time.Now()}
+
+		resp := responses[len(responses)-1]
+		if n < len(responses) {
+			resp = responses[n]
+		}
+		n++
+		w.WriteHeader(resp.status)
+		io.WriteString(w, resp.body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// next returns the next upload received, failing after the timeout
+func (s *stub) next(t *testing.T, timeout time.Duration) request {
+	t.Helper()
+	select {
+	case r := <-s.requests:
+		return r
+	case <-time.After(timeout):
+		t.Fatal("no upload received")
+	}
+	return request{}
+}
+
+// none checks that nothing is uploaded for a while
+func (s *stub) none(t *testing.T, wait time.Duration) {
+	t.Helper()
+	select {
+	case r := <-s.requests:
+		t.Fatalf("unexpected upload %s %s?%s", r.method, r.path, r.query.Encode())
+	case <-time.After(wait):
+	}
+}
+
+var observed = time.Date(2026, 10, 19, 17, 56, 0, 0, time.UTC)
+
+// reading returns an observation in the units of the readings: °C, %, hPa, km/h, mm and W/m²
+func reading(at time.Time) observation {
+	return observation{
+		time: at,
+		outdoor: map[string]float64{
+			"temperature":     20,
+			"humidity":        50,
+			"wind_speed":      16.09344,
+			"wind_gust":       32.18688,
+			"wind_direction":  270,
+			"rain_hourly":     2.54,
+			"rain_daily":      25.4,
+			"solar_radiation": 500,
+			"uv":              3,
+		},
+		indoor: map[string]float64{
+			"temperature":       22,
+			"humidity":          40,
+			"pressure_relative": 1013.25,
+		},
+	}
+}
+
+func newUploader(service string, endpoint string, interval string) *uploader {
+	u := config.Upload{Service: service, Station: "KSTATION1", Key: "secret", Interval: interval, URL: endpoint}
+	return &uploader{Upload: u, service: services[service], wake: make(chan struct{}, 1)}
+}
+
+// expect checks the query of an upload has each value
+func expect(t *testing.T, query url.Values, want map[string]string) {
+	t.Helper()
+	for name, value := range want {
+		if got := query.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestServices(t *testing.T) {
+	t.Run("wunderground", func(t *testing.T) {
+		s := newStub(t, response{200, "success\n"})
+		if err := newUploader("wunderground", s.URL+"/weatherstation/updateweatherstation.php", "").send(reading(observed)); err != nil {
+			t.Fatal(err)
+		}
+		r := s.next(t, time.Second)
+		if r.method != "GET" || r.path != "/weatherstation/updateweatherstation.php" || r.header.Get("User-Agent") != "weather" {
+			t.Errorf("%s %s User-Agent %q", r.method, r.path, r.header.Get("User-Agent"))
+		}
+		expect(t, r.query, map[string]string{
+			"ID": "KSTATION1", "PASSWORD": "secret", "action": "updateraw", "dateutc": "2026-10-19 17:56:00",
+			"tempf": "68.0", "humidity": "50", "dewptf": "48.7", "baromin": "29.921",
+			"windspeedmph": "10.0", "windgustmph": "20.0", "winddir": "270",
+			"rainin": "0.100", "dailyrainin": "1.000", "solarradiation": "500.0", "UV": "3",
+			"indoortempf": "71.6", "indoorhumidity": "40",
+		})
+		if _, ok := r.query["weeklyrainin"]; ok {
+			t.Error("weeklyrainin sent without a reading")
+		}
+	})
+
+	t.Run("wunderground rejected", func(t *testing.T) {
+		s := newStub(t, response{200, "INVALIDPASSWORDID|Password or key and/or id are incorrect\n"})
+		err := newUploader("wunderground", s.URL, "").send(reading(observed))
+		if _, retry := err.(retryable); err == nil || retry {
+			t.Fatalf("send() = %v, want a rejection", err)
+		}
+	})
+
+	t.Run("pwsweather", func(t *testing.T) {
+		s := newStub(t, response{200, `{"status":"ok"}`})
+		if err := newUploader("pwsweather", s.URL+"/api/v1/submitwx", "").send(reading(observed)); err != nil {
+			t.Fatal(err)
+		}
+		r := s.next(t, time.Second)
+		expect(t, r.query, map[string]string{"ID": "KSTATION1", "PASSWORD": "secret", "tempf": "68.0", "baromin": "29.921", "rainin": "0.100"})
+	})
+
+	t.Run("wow", func(t *testing.T) {
+		s := newStub(t, response{200, "{}"})
+		if err := newUploader("wow", s.URL+"/automaticreading", "").send(reading(observed)); err != nil {
+			t.Fatal(err)
+		}
+		r := s.next(t, time.Second)
+		expect(t, r.query, map[string]string{"siteid": "KSTATION1", "siteAuthenticationKey": "secret", "tempf": "68.0", "dailyrainin": "1.000"})
+		for _, name := range []string{"rainin", "ID", "PASSWORD"} {
+			if _, ok := r.query[name]; ok {
+				t.Errorf("%s sent to wow", name)
+			}
+		}
+	})
+
+	t.Run("windy", func(t *testing.T) {
+		s := newStub(t, response{200, ""})
+		if err := newUploader("windy", s.URL+"/pws/update", "").send(reading(observed)); err != nil {
+			t.Fatal(err)
+		}
+		r := s.next(t, time.Second)
+		if r.path != "/pws/update/secret" {
+			t.Errorf("path %s, want the key after the endpoint", r.path)
+		}
+		expect(t, r.query, map[string]string{
+			"station": "KSTATION1", "dateutc": "2026-10-19 17:56:00", "temp": "20.0", "humidity": "50", "dewpoint": "9.3",
+			"pressure": "101325", "wind": "4.5", "gust": "8.9", "winddir": "270", "precip": "2.5", "uv": "3",
+		})
+	})
+
+	t.Run("openweathermap", func(t *testing.T) {
+		s := newStub(t, response{204, ""})
+		if err := newUploader("openweathermap", s.URL+"/data/3.0/measurements", "").send(reading(observed)); err != nil {
+			t.Fatal(err)
+		}
+		r := s.next(t, time.Second)
+		if r.method != "POST" || r.query.Get("appid") != "secret" || r.header.Get("Content-Type") != "application/json" {
+			t.Errorf("%s appid %q Content-Type %q", r.method, r.query.Get("appid"), r.header.Get("Content-Type"))
+		}
+		measurements := []map[string]interface{}{}
+		if err := json.Unmarshal(r.body, &measurements); err != nil || len(measurements) != 1 {
+			t.Fatalf("body %s: %v", r.body, err)
+		}
+		want := map[string]interface{}{
+			"station_id": "KSTATION1", "dt": float64(observed.Unix()), "temperature": 20.0, "humidity": 50.0,
+			"dew_point": 9.26, "pressure": 1013.25, "wind_speed": 4.47, "wind_gust": 8.94, "wind_deg": 270.0, "rain_1h": 2.54,
+		}
+		for name, value := range want {
+			if measurements[0][name] != value {
+				t.Errorf("%s = %v, want %v", name, measurements[0][name], value)
+			}
+		}
+	})
+}
+
+func TestRetry(t *testing.T) {
+	for _, test := range []struct {
+		status int
+		retry  bool
+	}{
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusBadGateway, true},
+		{http.StatusServiceUnavailable, true},
+		{http.StatusBadRequest, false},
+		{http.StatusUnauthorized, false},
+		{http.StatusForbidden, false},
+		{http.StatusNotFound, false},
+	} {
+		s := newStub(t, response{test.status, "no"})
+		err := newUploader("windy", s.URL, "").send(reading(observed))
+		if _, retry := err.(retryable); err == nil || retry != test.retry {
+			t.Errorf("%d: send() = %v, retryable %v, want %v", test.status, err, retry, test.retry)
+		}
+	}
+
+	// a service that cannot be reached is retried
+	s := newStub(t, response{200, ""})
+	s.Close()
+	if _, retry := newUploader("windy", s.URL, "").send(reading(observed)).(retryable); !retry {
+		t.Error("connection refused is not retried")
+	}
+}
+
+// exported returns the exporter report of the uploaders
+func exported(u ...*uploader) map[string]string {
+	uploaders = u
+	report := map[string]string{}
+	Report(report)
+	return report
+}
+
+func TestRun(t *testing.T) {
+	// the uploaders keep running after each test, so the backoff is not put back
+	minBackoff = 50 * time.Millisecond
+
+	t.Run("rate limited", func(t *testing.T) {
+		s := newStub(t, response{200, ""})
+		u := newUploader("windy", s.URL, "300ms")
+		go u.run()
+
+		u.queue(reading(observed))
+		first := s.next(t, time.Second)
+
+		// observations received before the interval is up replace each other, the latest is sent
+		u.queue(reading(observed.Add(time.Minute)))
+		u.queue(reading(observed.Add(2 * time.Minute)))
+		second := s.next(t, 2*time.Second)
+		if gap := second.at.Sub(first.at); gap < 300*time.Millisecond {
+			t.Errorf("uploaded %s after the previous upload, want at least 300ms", gap)
+		}
+		if got := second.query.Get("dateutc"); got != "2026-10-19 17:58:00" {
+			t.Errorf("uploaded the observation from %s, want the latest", got)
+		}
+		s.none(t, 500*time.Millisecond)
+
+		report := exported(u)
+		labels := `{service="windy",station="KSTATION1",result="success"}`
+		if got := report["weather_uploads_total"+labels]; got != "2" {
+			t.Errorf("weather_uploads_total%s = %q, want 2", labels, got)
+		}
+		if got := report[`weather_upload_last_success_timestamp_seconds{service="windy",station="KSTATION1"}`]; got == "" {
+			t.Error("no last success timestamp")
+		}
+	})
+
+	t.Run("retried while unavailable", func(t *testing.T) {
+		s := newStub(t, response{503, "busy"}, response{429, "slow down"}, response{200, ""})
+		u := newUploader("windy", s.URL, "1h")
+		go u.run()
+
+		u.queue(reading(observed))
+		for i := 0; i < 3; i++ {
+			if r := s.next(t, time.Second); r.query.Get("dateutc") != "2026-10-19 17:56:00" {
+				t.Errorf("attempt %d uploaded %s", i, r.query.Get("dateutc"))
+			}
+		}
+		s.none(t, 300*time.Millisecond)
+
+		report := exported(u)
+		for result, want := range map[string]string{"success": "1", "failure": "2"} {
+			series := `weather_uploads_total{service="windy",station="KSTATION1",result="` + result + `"}`
+			if report[series] != want {
+				t.Errorf("%s = %q, want %s", series, report[series], want)
+			}
+		}
+	})
+
+	t.Run("rejected uploads are dropped", func(t *testing.T) {
+		s := newStub(t, response{401, "invalid key"})
+		u := newUploader("windy", s.URL, "100ms")
+		go u.run()
+
+		u.queue(reading(observed))
+		s.next(t, time.Second)
+		s.none(t, 300*time.Millisecond)
+
+		report := exported(u)
+		if got := report[`weather_uploads_total{service="windy",station="KSTATION1",result="failure"}`]; got != "1" {
+			t.Errorf("failures = %q, want 1", got)
+		}
+		if got := report[`weather_uploads_total{service="windy",station="KSTATION1",result="success"}`]; got != "0" {
+			t.Errorf("successes = %q, want 0", got)
+		}
+		if _, ok := report[`weather_upload_last_success_timestamp_seconds{service="windy",station="KSTATION1"}`]; ok {
+			t.Error("last success timestamp without a success")
+		}
+	})
+}